@@ -0,0 +1,45 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package options
+
+import (
+	"context"
+	migrate "github.com/hchenc/migrator/cmd"
+	"github.com/hchenc/migrator/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+var baselineVersion string
+
+// baselineCmd represents the baseline command
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "mark migrations up to the target version as applied without running them",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "baseline", "baseline", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			mg.DryRun = dryRun
+			return mg.BaselineContext(ctx, baselineVersion)
+		})
+	},
+}
+
+func init() {
+	migrate.RootCmd.AddCommand(baselineCmd)
+	baselineCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations which would be marked as applied without recording them")
+	baselineCmd.Flags().StringVar(&baselineVersion, "version", "", "version up to which migrations are marked as applied")
+	_ = baselineCmd.MarkFlagRequired("version")
+}
@@ -0,0 +1,56 @@
+package options
+
+import (
+	"fmt"
+	migrate "github.com/hchenc/migrator/cmd"
+	"github.com/hchenc/migrator/pkg/client"
+	"github.com/hchenc/migrator/pkg/constants"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// newMigratorClient builds a migrator client from the global flags
+func newMigratorClient() (*client.Migrator, error) {
+	dataUrl, err := url.Parse(migrate.DatabaseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", merrors.ErrInvalidUrl, err)
+	}
+	// keep stdout for the result document when it is machine readable
+	log := os.Stdout
+	if migrate.Output != constants.OutputText {
+		log = os.Stderr
+	}
+	mg, err := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, log, dump)
+	if err != nil {
+		return nil, err
+	}
+	mg.SchemaFile = migrate.SchemaFile
+	mg.LockTimeout = migrate.LockTimeout
+	mg.MigrationTimeout = migrate.MigrationTimeout
+	mg.LockWaitTimeout = migrate.LockWaitTimeout
+	mg.Retry = client.RetryPolicy{MaxAttempts: migrate.RetryAttempts, Backoff: migrate.RetryBackoff}
+	mg.OutOfOrder = migrate.OutOfOrder
+	if mg.Placeholders, err = placeholders(); err != nil {
+		return nil, err
+	}
+	return mg, nil
+}
+
+// placeholders merges the placeholders of the config file with the --var flags, keyed by
+// lower case name as the config file keys are
+func placeholders() (map[string]string, error) {
+	values := map[string]string{}
+	for name, value := range migrate.Placeholders {
+		values[strings.ToLower(name)] = value
+	}
+	for _, v := range migrate.Vars {
+		pair := strings.SplitN(v, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("invalid --var `%s`, expected name=value", v)
+		}
+		values[strings.ToLower(pair[0])] = pair[1]
+	}
+	return values, nil
+}
@@ -0,0 +1,59 @@
+package options
+
+import (
+	"reflect"
+	"testing"
+
+	migrate "github.com/hchenc/migrator/cmd"
+)
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		vars    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "config only",
+			config: map[string]string{"schema": "app"},
+			want:   map[string]string{"schema": "app"},
+		},
+		{
+			name:   "var overrides config",
+			config: map[string]string{"schema": "app", "owner": "admin"},
+			vars:   []string{"Schema=test"},
+			want:   map[string]string{"schema": "test", "owner": "admin"},
+		},
+		{
+			name: "value containing equal signs",
+			vars: []string{"filter=a=b"},
+			want: map[string]string{"filter": "a=b"},
+		},
+		{
+			name: "empty value",
+			vars: []string{"suffix="},
+			want: map[string]string{"suffix": ""},
+		},
+		{name: "missing value", vars: []string{"schema"}, wantErr: true},
+		{name: "missing name", vars: []string{"=app"}, wantErr: true},
+	}
+
+	defer func(config map[string]string, vars []string) {
+		migrate.Placeholders, migrate.Vars = config, vars
+	}(migrate.Placeholders, migrate.Vars)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrate.Placeholders, migrate.Vars = test.config, test.vars
+			got, err := placeholders()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("placeholders() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("placeholders() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
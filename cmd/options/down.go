@@ -16,11 +16,9 @@ limitations under the License.
 package options
 
 import (
-	"fmt"
+	"context"
 	migrate "github.com/hchenc/migrator/cmd"
 	"github.com/hchenc/migrator/pkg/client"
-	"net/url"
-	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -31,25 +29,17 @@ var down uint
 var downCmd = &cobra.Command{
 	Use:   "down",
 	Short: "rollback target step to target version",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("----------------")
-		fmt.Println("start to down")
-		dataUrl, err := url.Parse(migrate.DatabaseUrl)
-		if err != nil {
-			panic(err)
-		}
-		mg := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, os.Stdout, dump)
-		err = mg.Down(down)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("end to down")
-		fmt.Println("----------------")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "down", "down", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			mg.DryRun = dryRun
+			return mg.DownContext(ctx, down)
+		})
 	},
 }
 
 func init() {
 	migrate.RootCmd.AddCommand(downCmd)
+	downCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations which would be executed without running them")
 
 	// Here you will define your flags and configuration settings.
 	downCmd.Flags().UintVarP(&down, "step", "s", 1, "down step to rollback, if 1 same to rollback")
@@ -16,39 +16,40 @@ limitations under the License.
 package options
 
 import (
-	"fmt"
+	"context"
 	migrate "github.com/hchenc/migrator/cmd"
 	"github.com/hchenc/migrator/pkg/client"
-	"net/url"
-	"os"
 
 	"github.com/spf13/cobra"
 )
 
 var dump bool
+var createIfMissing bool
+var allowModified bool
+var dryRun bool
 
 // migrateCmd represents the migrate command
 var migrateCmd = &cobra.Command{
 	Use:   "migrate",
 	Short: "migrate to the latest version",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("----------------")
-		fmt.Println("start to migrate")
-		dataUrl, err := url.Parse(migrate.DatabaseUrl)
-		if err != nil {
-			panic(err)
-		}
-		mg := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, os.Stdout, dump)
-		err = mg.Migrate()
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("end to migrate")
-		fmt.Println("----------------")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "migrate", "migrate", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			mg.DryRun = dryRun
+			mg.AllowModified = allowModified
+			if createIfMissing {
+				if err := mg.CreateIfMissingContext(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return mg.MigrateContext(ctx)
+		})
 	},
 }
 
 func init() {
 	migrate.RootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations which would be executed without running them")
 	migrateCmd.Flags().BoolVarP(&dump, "autodump", "a", false, "auto dump schema before migrate")
+	migrateCmd.Flags().BoolVar(&allowModified, "allow-modified", false, "warn instead of failing when applied migrations were modified")
+	migrateCmd.Flags().BoolVar(&createIfMissing, "create-if-missing", false, "create database before migrate if it does not exist")
 }
@@ -16,12 +16,9 @@ limitations under the License.
 package options
 
 import (
-	"fmt"
+	"context"
 	migrate "github.com/hchenc/migrator/cmd"
-
 	"github.com/hchenc/migrator/pkg/client"
-	"net/url"
-	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -32,20 +29,14 @@ var message string
 var newCmd = &cobra.Command{
 	Use:   "new",
 	Short: "generate a new migration file",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("----------------")
-		fmt.Println("start to generate migration script")
-		dataUrl, err := url.Parse(migrate.DatabaseUrl)
-		if err != nil {
-			panic(err)
-		}
-		mg := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, os.Stdout, dump)
-		err = mg.New(message)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("end to generate migration script")
-		fmt.Println("----------------")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "new", "generate migration script", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			path, err := mg.New(message)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"path": path}, nil
+		})
 	},
 }
 
@@ -0,0 +1,80 @@
+package options
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	migrate "github.com/hchenc/migrator/cmd"
+	"github.com/hchenc/migrator/pkg/client"
+	"github.com/hchenc/migrator/pkg/constants"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// document is printed by commands when the output is json or yaml
+type document struct {
+	Command  string      `json:"command" yaml:"command"`
+	Result   interface{} `json:"result,omitempty" yaml:"result,omitempty"`
+	Error    string      `json:"error,omitempty" yaml:"error,omitempty"`
+	ExitCode int         `json:"exit_code" yaml:"exit_code"`
+}
+
+// runCommand runs action against a new migrator client. With text output it is wrapped
+// in the usual banners, otherwise its result is printed as a single document, failures included.
+// ctx is cancelled when the process is interrupted.
+func runCommand(ctx context.Context, command, title string, action func(ctx context.Context, mg *client.Migrator) (interface{}, error)) error {
+	text := migrate.Output == constants.OutputText
+	if text {
+		fmt.Println("----------------")
+		if title != "" {
+			fmt.Printf("start to %s\n", title)
+		}
+	}
+
+	var result interface{}
+	mg, err := newMigratorClient()
+	if err == nil {
+		result, err = action(ctx, mg)
+	}
+
+	if !text {
+		if perr := printDocument(command, result, err); perr != nil {
+			return perr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if title != "" {
+		fmt.Printf("end to %s\n", title)
+	}
+	fmt.Println("----------------")
+	return nil
+}
+
+// printDocument writes the result of command to stdout in the selected output format
+func printDocument(command string, result interface{}, err error) error {
+	doc := document{Command: command, Result: result, ExitCode: merrors.ExitCode(err)}
+	if err != nil {
+		doc.Error = err.Error()
+	}
+
+	var data []byte
+	var merr error
+	if migrate.Output == constants.OutputYaml {
+		data, merr = yaml.Marshal(doc)
+	} else {
+		data, merr = json.MarshalIndent(doc, "", "  ")
+		data = append(data, '\n')
+	}
+	if merr != nil {
+		return merr
+	}
+
+	_, werr := os.Stdout.Write(data)
+	return werr
+}
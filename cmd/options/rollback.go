@@ -16,12 +16,9 @@ limitations under the License.
 package options
 
 import (
-	"fmt"
+	"context"
 	migrate "github.com/hchenc/migrator/cmd"
-
 	"github.com/hchenc/migrator/pkg/client"
-	"net/url"
-	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -30,23 +27,15 @@ import (
 var rollbackCmd = &cobra.Command{
 	Use:   "rollback",
 	Short: "rollback to the most recent version",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("----------------")
-		fmt.Println("start to rollback")
-		dataUrl, err := url.Parse(migrate.DatabaseUrl)
-		if err != nil {
-			panic(err)
-		}
-		mg := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, os.Stdout, dump)
-		err = mg.Rollback()
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("end to rollback")
-		fmt.Println("----------------")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "rollback", "rollback", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			mg.DryRun = dryRun
+			return mg.RollbackContext(ctx)
+		})
 	},
 }
 
 func init() {
 	migrate.RootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations which would be executed without running them")
 }
@@ -0,0 +1,80 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package options
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	migrate "github.com/hchenc/migrator/cmd"
+	"github.com/hchenc/migrator/pkg/constants"
+	"github.com/hchenc/migrator/pkg/server"
+	"net"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+var listen string
+var noAuth bool
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve the rest api to manage the database",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrate.ApiToken == "" && !noAuth {
+			return fmt.Errorf("an api token is required, set api-token in the config file or pass --no-auth")
+		}
+		mg, err := newMigratorClient()
+		if err != nil {
+			return err
+		}
+		token := migrate.ApiToken
+		if noAuth {
+			token = ""
+		}
+		ctx := cmd.Context()
+		srv := &http.Server{
+			Addr:    listen,
+			Handler: server.NewServer(mg, token).Handler(),
+			// requests are cancelled once interrupted, rolling back the running migration
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		}
+
+		// stop accepting requests once interrupted, waiting for the running operation to roll back
+		shutdown := make(chan error, 1)
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), constants.ShutdownTimeout)
+			defer cancel()
+			shutdown <- srv.Shutdown(shutdownCtx)
+		}()
+
+		fmt.Printf("Listening on %s\n", listen)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return <-shutdown
+	},
+}
+
+func init() {
+	migrate.RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&listen, "listen", ":8080", "address the rest api listens on")
+	serveCmd.Flags().StringVar(&migrate.ApiToken, "api-token", "", "bearer token required by the rest api")
+	serveCmd.Flags().BoolVar(&noAuth, "no-auth", false, "serve the rest api without authentication")
+}
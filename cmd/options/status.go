@@ -16,39 +16,44 @@ limitations under the License.
 package options
 
 import (
+	"context"
 	"fmt"
 	migrate "github.com/hchenc/migrator/cmd"
-
 	"github.com/hchenc/migrator/pkg/client"
-	"net/url"
+	"github.com/hchenc/migrator/pkg/constants"
+	merrors "github.com/hchenc/migrator/pkg/errors"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
 var quiet bool
+var exitCode bool
 
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "list applied and pending migration script",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("----------------")
-		dataUrl, err := url.Parse(migrate.DatabaseUrl)
-		if err != nil {
-			panic(err)
-		}
-		mg := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, os.Stdout, dump)
-		_, err = mg.Status(quiet)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("----------------")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "status", "", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			report, err := mg.StatusContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if migrate.Output == constants.OutputText && !quiet {
+				report.Print(os.Stdout)
+			}
+			if exitCode && len(report.Pending) > 0 {
+				return report, fmt.Errorf("%w: %d", merrors.ErrPendingMigrations, len(report.Pending))
+			}
+			return report, nil
+		})
 	},
 }
 
 func init() {
 	migrate.RootCmd.AddCommand(statusCmd)
 	statusCmd.Flags().BoolVar(&quiet, "quiet", false, "don't output any text")
+	statusCmd.Flags().BoolVar(&exitCode, "exit-code", false, "return a non-zero exit code if there are pending migrations")
 
 }
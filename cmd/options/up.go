@@ -16,12 +16,9 @@ limitations under the License.
 package options
 
 import (
-	"fmt"
+	"context"
 	migrate "github.com/hchenc/migrator/cmd"
-
 	"github.com/hchenc/migrator/pkg/client"
-	"net/url"
-	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -32,24 +29,24 @@ var up uint
 var upCmd = &cobra.Command{
 	Use:   "up",
 	Short: "migrate target step to target version",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("----------------")
-		fmt.Println("start to up")
-		dataUrl, err := url.Parse(migrate.DatabaseUrl)
-		if err != nil {
-			panic(err)
-		}
-		mg := client.NewMigratorClient(dataUrl, migrate.DatabaseUser, migrate.DatabasePass, migrate.MigrationLocation, migrate.MigrationTable, os.Stdout, dump)
-		err = mg.Up(up)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("end to up")
-		fmt.Println("----------------")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "up", "up", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			mg.DryRun = dryRun
+			mg.AllowModified = allowModified
+			if createIfMissing {
+				if err := mg.CreateIfMissingContext(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return mg.UpContext(ctx, up)
+		})
 	},
 }
 
 func init() {
 	migrate.RootCmd.AddCommand(upCmd)
+	upCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations which would be executed without running them")
 	upCmd.Flags().UintVarP(&up, "step", "s", 1, "up step to migrate")
+	upCmd.Flags().BoolVar(&allowModified, "allow-modified", false, "warn instead of failing when applied migrations were modified")
+	upCmd.Flags().BoolVar(&createIfMissing, "create-if-missing", false, "create database before up if it does not exist")
 }
@@ -0,0 +1,39 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package options
+
+import (
+	"context"
+	migrate "github.com/hchenc/migrator/cmd"
+	"github.com/hchenc/migrator/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check applied migration files were not modified",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd.Context(), "validate", "validate", func(ctx context.Context, mg *client.Migrator) (interface{}, error) {
+			return nil, mg.ValidateContext(ctx)
+		})
+	},
+}
+
+func init() {
+	migrate.RootCmd.AddCommand(validateCmd)
+}
@@ -16,17 +16,36 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/hchenc/migrator/pkg/constants"
+	merrors "github.com/hchenc/migrator/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var cfgFile string
 
 var MigrationLocation string
 var MigrationTable string
+var SchemaFile string
+var LockTimeout time.Duration
+var MigrationTimeout time.Duration
+var LockWaitTimeout time.Duration
+var RetryAttempts int
+var RetryBackoff time.Duration
+var Vars []string
+
+// Placeholders are the placeholder values of the config file, overridden by Vars
+var Placeholders map[string]string
+var OutOfOrder string
+var ApiToken string
+var Output string
 var DatabaseUrl string
 var DatabaseUser string
 var DatabasePass string
@@ -40,12 +59,35 @@ provide command cli and rest api to manage the database.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	// errors are reported with their exit code, not with the usage text
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		switch Output {
+		case constants.OutputText, constants.OutputJson, constants.OutputYaml:
+			return nil
+		}
+		return fmt.Errorf("unknown output format `%s`, expected %s, %s or %s",
+			Output, constants.OutputText, constants.OutputJson, constants.OutputYaml)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the RootCmd.
+// The process exits with the code matching the returned error, see pkg/errors.
+// SIGINT and SIGTERM cancel the running command, rolling back the migration being applied,
+// a second signal kills the process.
 func Execute() {
-	cobra.CheckErr(RootCmd.Execute())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := RootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		os.Exit(merrors.ExitCode(err))
+	}
 }
 
 func init() {
@@ -59,6 +101,15 @@ func init() {
 
 	RootCmd.PersistentFlags().StringVarP(&MigrationLocation, "migration-location", "d", "./db/migration", "migration file directory where to store migration script")
 	RootCmd.PersistentFlags().StringVarP(&MigrationTable, "migration-table", "t", "schema_history", "database table name where to store schema change record")
+	RootCmd.PersistentFlags().StringVar(&SchemaFile, "schema-file", "./db/schema.sql", "schema file path where to dump database schema")
+	RootCmd.PersistentFlags().DurationVar(&LockTimeout, "lock-timeout", 5*time.Minute, "how long to wait for another migrator to release its lock, 0 waits forever")
+	RootCmd.PersistentFlags().DurationVar(&MigrationTimeout, "migration-timeout", 0, "how long a migration may run unless it sets timeout:<duration>, 0 for no limit")
+	RootCmd.PersistentFlags().DurationVar(&LockWaitTimeout, "lock-wait-timeout", 0, "how long statements wait for table and row locks unless the migration sets lock_wait_timeout:<duration>, 0 for the database default")
+	RootCmd.PersistentFlags().IntVar(&RetryAttempts, "retry-attempts", constants.DefaultRetryAttempts, "how many times a transactional migration is run when it fails with a transient error such as a deadlock, 1 disables retries")
+	RootCmd.PersistentFlags().DurationVar(&RetryBackoff, "retry-backoff", constants.DefaultRetryBackoff, "delay before retrying a migration, doubled after each attempt")
+	RootCmd.PersistentFlags().StringArrayVar(&Vars, "var", nil, "value of a ${name} placeholder of the migrations as name=value, can be repeated")
+	RootCmd.PersistentFlags().StringVar(&OutOfOrder, "out-of-order", constants.DefaultOutOfOrder, "policy for pending migrations older than the latest applied one: allow, warn or fail")
+	RootCmd.PersistentFlags().StringVarP(&Output, "output", "o", constants.OutputText, "output format of commands: text, json or yaml")
 	RootCmd.PersistentFlags().StringVarP(&DatabaseUrl, "database-url", "l", "", "database url")
 	RootCmd.PersistentFlags().StringVarP(&DatabaseUser, "database-user", "u", "", "database user")
 	RootCmd.PersistentFlags().StringVarP(&DatabasePass, "database-password", "p", "", "database password")
@@ -83,14 +134,18 @@ func initConfig() {
 			for flag, value := range map[*string]string{
 				&MigrationLocation: "migrator.location",
 				&MigrationTable:    "migrator.table",
+				&SchemaFile:        "migrator.schema-file",
 				&DatabaseUrl:       "spring.datasource.url",
 				&DatabaseUser:      "spring.datasource.username",
 				&DatabasePass:      "spring.datasource.password",
+				&ApiToken:          "migrator.api-token",
+				&OutOfOrder:        "migrator.out-of-order",
 			} {
 				if viper.GetString(value) != "" {
 					*flag = viper.GetString(value)
 				}
 			}
+			Placeholders = viper.GetStringMapString("migrator.placeholders")
 			DatabaseUrl = strings.Split(DatabaseUrl[5:], "?")[0]
 		} else {
 			fmt.Fprintln(os.Stderr, fmt.Sprintf("Error while loading config file: %s", viper.ConfigFileUsed()))
@@ -102,15 +157,18 @@ func initConfig() {
 			for flag, value := range map[*string]string{
 				&MigrationLocation: "migration-location",
 				&MigrationTable:    "migration-table",
+				&SchemaFile:        "schema-file",
 				&DatabaseUrl:       "database-url",
 				&DatabaseUser:      "database-user",
 				&DatabasePass:      "database-password",
+				&ApiToken:          "api-token",
+				&OutOfOrder:        "out-of-order",
 			} {
 				if viper.GetString(value) != "" {
-					fmt.Println(viper.GetString(value))
 					*flag = viper.GetString(value)
 				}
 			}
+			Placeholders = viper.GetStringMapString("placeholders")
 		} else {
 			fmt.Fprintln(os.Stderr, fmt.Sprintf("Error while loading config file: %s", viper.ConfigFileUsed()))
 			fmt.Fprintln(os.Stderr, "Error: ", err.Error())
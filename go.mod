@@ -4,7 +4,10 @@ go 1.16
 
 require (
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/lib/pq v1.10.2
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.8.1
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -19,6 +19,8 @@ import (
 	"github.com/hchenc/migrator/cmd"
 	_ "github.com/hchenc/migrator/cmd/options"
 	_ "github.com/hchenc/migrator/pkg/drivers/mysql"
+	_ "github.com/hchenc/migrator/pkg/drivers/postgres"
+	_ "github.com/hchenc/migrator/pkg/drivers/sqlite"
 )
 
 func main() {
@@ -1,22 +1,34 @@
 package backends
 
 import (
+	"context"
 	"database/sql"
+	"github.com/hchenc/migrator/pkg/utils"
 	"io"
 	"net/url"
+	"time"
 )
 
 type Interface interface {
 	OpenDatabase() (*sql.DB, error)
-	DatabaseExists() (bool, error)
-	CreateDatabase() error
-	DropDatabase() error
-	DumpSchema(db *sql.DB) ([]byte, error)
-	CreateMigrationsTable(db *sql.DB) error
-	SelectMigrations(db *sql.DB, id int) (map[string]bool, error)
-	InsertMigration(tx Transaction, version string) error
-	DeleteMigration(tx Transaction, version string) error
-	Ping() error
+	DatabaseExists(ctx context.Context) (bool, error)
+	CreateDatabase(ctx context.Context) error
+	DropDatabase(ctx context.Context) error
+	DumpSchema(ctx context.Context, db *sql.DB) ([]byte, error)
+	CreateMigrationsTable(ctx context.Context, db *sql.DB) error
+	MigrationsTableColumns(ctx context.Context, db *sql.DB) ([]string, error)
+	SelectMigrationVersions(ctx context.Context, db *sql.DB) (map[string]MigrationRecord, error)
+	SelectMigrations(ctx context.Context, db *sql.DB, id int) (map[string]MigrationRecord, error)
+	InsertMigration(ctx context.Context, tx Transaction, record MigrationRecord) error
+	UpdateMigration(ctx context.Context, tx Transaction, record MigrationRecord) error
+	DeleteMigration(ctx context.Context, tx Transaction, version string) error
+	Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (func() error, error)
+	SetLockWaitTimeout(ctx context.Context, tx Transaction, timeout time.Duration) (func() error, error)
+	KillOnCancel(ctx context.Context, db *sql.DB, tx Transaction) (func(), error)
+	TransactionalDDL() bool
+	IsRetryable(err error) bool
+	Ping(ctx context.Context) error
+	Dialect() utils.Dialect
 }
 
 // Transaction to abstract tx from sql and sqlx
@@ -24,6 +36,9 @@ type Transaction interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 type BackendConfig struct {
@@ -33,3 +48,16 @@ type BackendConfig struct {
 	MigrationsTable string
 	Log             io.Writer
 }
+
+// MigrationRecord is a row of the migrations table
+type MigrationRecord struct {
+	Version       string
+	Description   string
+	Checksum      string
+	AppliedAt     time.Time
+	AppliedBy     string
+	Hostname      string
+	ExecutionTime time.Duration
+	// Dirty is set while a migration running outside of a transaction has not completed
+	Dirty bool
+}
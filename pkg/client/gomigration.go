@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/utils"
+)
+
+// goMigrationExt is the extension of the filename given to go migrations
+const goMigrationExt = ".go"
+
+var goMigrationVersionRegExp = regexp.MustCompile(`^\d+$`)
+
+// GoMigration is a migration written in go, see RegisterGoMigration
+type GoMigration struct {
+	Version string
+	Name    string
+	Up      func(backends.Transaction) error
+	Down    func(backends.Transaction) error
+	Options MigrationOptions
+}
+
+// Filename is the name the go migration is listed, ordered and reported with, e.g. 20210101000000_backfill.go
+func (m GoMigration) Filename() string {
+	return fmt.Sprintf("%s_%s%s", m.Version, m.Name, goMigrationExt)
+}
+
+// RegisterGoMigration adds a migration written in go, ordered by version with the migration files
+// and recorded in the same migrations table. The options are the same as the ones of the
+// '-- migrate:up' directive, e.g. "transaction:false" runs up and down outside of a transaction.
+// A nil down function rolls back without doing anything.
+func (migrator *Migrator) RegisterGoMigration(version, name string, up, down func(backends.Transaction) error, options ...string) error {
+	if !goMigrationVersionRegExp.MatchString(version) {
+		return fmt.Errorf("invalid go migration version `%s`, it must be numeric", version)
+	}
+	if up == nil {
+		return fmt.Errorf("go migration %s has no up function", version)
+	}
+	if _, ok := migrator.goMigrations[version]; ok {
+		return fmt.Errorf("go migration %s is already registered", version)
+	}
+
+	opts, err := parseMigrationOptions(strings.Join(options, " "))
+	if err != nil {
+		return fmt.Errorf("go migration %s: %s", version, err)
+	}
+
+	if migrator.goMigrations == nil {
+		migrator.goMigrations = map[string]GoMigration{}
+	}
+	migrator.goMigrations[version] = GoMigration{
+		Version: version,
+		Name:    name,
+		Up:      up,
+		Down:    down,
+		Options: opts,
+	}
+	return nil
+}
+
+// goMigration returns the go migration registered under filename, if any
+func (migrator *Migrator) goMigration(filename string) (GoMigration, bool) {
+	m, ok := migrator.goMigrations[utils.MigrationVersion(filename)]
+	if !ok || m.Filename() != filename {
+		return GoMigration{}, false
+	}
+	return m, true
+}
+
+// parseGoMigration returns the up and down migrations of a go migration
+func parseGoMigration(m GoMigration) (Migration, Migration) {
+	up := Migration{Options: m.Options, Func: m.Up}
+	down := Migration{Options: m.Options, Func: m.Down}
+	return up, down
+}
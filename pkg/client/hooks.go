@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	merrors "github.com/hchenc/migrator/pkg/errors"
+	"github.com/hchenc/migrator/pkg/utils"
+)
+
+// Hooks are called around the migrations executed by a command, e.g. to refresh caches or
+// notify other services after a schema change. Migrate hooks are called when the plan applies
+// migrations, rollback hooks when it only rolls back. Baselines and dry runs call no hook.
+// Embed NoopHooks to implement only some of them.
+type Hooks interface {
+	// BeforeMigrate is called before applying the migrations of plan, an error cancels them
+	BeforeMigrate(ctx context.Context, plan *Plan) error
+	// AfterMigrate is called once every migration was applied
+	AfterMigrate(ctx context.Context, executed []MigrationResult) error
+	// BeforeRollback is called before rolling back the migrations of plan, an error cancels them
+	BeforeRollback(ctx context.Context, plan *Plan) error
+	// AfterRollback is called once every migration was rolled back
+	AfterRollback(ctx context.Context, executed []MigrationResult) error
+	// BeforeEach is called before applying or rolling back a migration, an error cancels it
+	BeforeEach(ctx context.Context, step PlanStep) error
+	// AfterEach is called after a migration was applied or rolled back
+	AfterEach(ctx context.Context, result MigrationResult) error
+	// OnError is called when a migration failed, the command then returns err
+	OnError(ctx context.Context, step PlanStep, err error)
+}
+
+// NoopHooks implements Hooks doing nothing
+type NoopHooks struct{}
+
+func (NoopHooks) BeforeMigrate(ctx context.Context, plan *Plan) error                 { return nil }
+func (NoopHooks) AfterMigrate(ctx context.Context, executed []MigrationResult) error  { return nil }
+func (NoopHooks) BeforeRollback(ctx context.Context, plan *Plan) error                { return nil }
+func (NoopHooks) AfterRollback(ctx context.Context, executed []MigrationResult) error { return nil }
+func (NoopHooks) BeforeEach(ctx context.Context, step PlanStep) error                 { return nil }
+func (NoopHooks) AfterEach(ctx context.Context, result MigrationResult) error         { return nil }
+func (NoopHooks) OnError(ctx context.Context, step PlanStep, err error)               {}
+
+// Names of the sql callback files, without their .sql extension, run by sqlCallbacks
+const (
+	callbackBeforeMigrate      = "beforeMigrate"
+	callbackBeforeEachMigrate  = "beforeEachMigrate"
+	callbackAfterEachMigrate   = "afterEachMigrate"
+	callbackAfterMigrate       = "afterMigrate"
+	callbackAfterMigrateError  = "afterMigrateError"
+	callbackBeforeRollback     = "beforeRollback"
+	callbackBeforeEachRollback = "beforeEachRollback"
+	callbackAfterEachRollback  = "afterEachRollback"
+	callbackAfterRollback      = "afterRollback"
+	callbackAfterRollbackError = "afterRollbackError"
+	callbackExt                = ".sql"
+)
+
+var callbackNames = []string{
+	callbackBeforeMigrate, callbackBeforeEachMigrate, callbackAfterEachMigrate, callbackAfterMigrate,
+	callbackAfterMigrateError, callbackBeforeRollback, callbackBeforeEachRollback, callbackAfterEachRollback,
+	callbackAfterRollback, callbackAfterRollbackError,
+}
+
+// sqlCallbacks are the hooks running the sql callback files found next to the migration files,
+// e.g. afterMigrate.sql. Their statements run outside of the migration transactions.
+type sqlCallbacks struct {
+	migrator *Migrator
+	db       *sql.DB
+	// statements of the callback files by name, missing files have none
+	statements map[string][]utils.Statement
+}
+
+// newSQLCallbacks reads and splits the callback files so that invalid ones fail before any migration
+func (migrator *Migrator) newSQLCallbacks(db *sql.DB) (*sqlCallbacks, error) {
+	callbacks := &sqlCallbacks{migrator: migrator, db: db, statements: map[string][]utils.Statement{}}
+	for _, name := range callbackNames {
+		data, err := migrator.source().ReadMigrationFile(name + callbackExt)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		contents, err := utils.ReplacePlaceholders(string(data), migrator.placeholder)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %s", merrors.ErrInvalidMigration, name+callbackExt, err)
+		}
+		statements, err := utils.SplitStatements(contents, migrator.backend.Dialect())
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %s", merrors.ErrInvalidMigration, name+callbackExt, err)
+		}
+		callbacks.statements[name] = statements
+	}
+	return callbacks, nil
+}
+
+func (c *sqlCallbacks) BeforeMigrate(ctx context.Context, plan *Plan) error {
+	return c.run(ctx, callbackBeforeMigrate)
+}
+
+func (c *sqlCallbacks) AfterMigrate(ctx context.Context, executed []MigrationResult) error {
+	return c.run(ctx, callbackAfterMigrate)
+}
+
+func (c *sqlCallbacks) BeforeRollback(ctx context.Context, plan *Plan) error {
+	return c.run(ctx, callbackBeforeRollback)
+}
+
+func (c *sqlCallbacks) AfterRollback(ctx context.Context, executed []MigrationResult) error {
+	return c.run(ctx, callbackAfterRollback)
+}
+
+func (c *sqlCallbacks) BeforeEach(ctx context.Context, step PlanStep) error {
+	if step.Direction == DirectionDown {
+		return c.run(ctx, callbackBeforeEachRollback)
+	}
+	return c.run(ctx, callbackBeforeEachMigrate)
+}
+
+func (c *sqlCallbacks) AfterEach(ctx context.Context, result MigrationResult) error {
+	if result.Direction == DirectionDown {
+		return c.run(ctx, callbackAfterEachRollback)
+	}
+	return c.run(ctx, callbackAfterEachMigrate)
+}
+
+// OnError runs the error callback, its own failure is only reported as the command fails anyway
+func (c *sqlCallbacks) OnError(ctx context.Context, step PlanStep, err error) {
+	name := callbackAfterMigrateError
+	if step.Direction == DirectionDown {
+		name = callbackAfterRollbackError
+	}
+	if err := c.run(ctx, name); err != nil {
+		fmt.Fprintf(c.migrator.Log, "Failed to run callback: %s\n", err)
+	}
+}
+
+// run executes the statements of a callback file, if it exists
+func (c *sqlCallbacks) run(ctx context.Context, name string) error {
+	statements, ok := c.statements[name]
+	if !ok {
+		return nil
+	}
+
+	filename := name + callbackExt
+	fmt.Fprintf(c.migrator.Log, "Running callback: %s\n", filename)
+	for i, statement := range statements {
+		result, err := c.db.ExecContext(ctx, statement.SQL)
+		if err != nil {
+			return &merrors.MigrationError{
+				Filename:  filename,
+				Statement: statement.SQL,
+				Index:     i + 1,
+				Line:      statement.Line,
+				Err:       err,
+			}
+		} else if c.migrator.Verbose {
+			c.migrator.printVerbose(result)
+		}
+	}
+	return nil
+}
+
+// hooked reports whether running the plan calls hooks, not when it is empty or only baselines
+func (plan *Plan) hooked() bool {
+	for _, step := range plan.Steps {
+		if step.Direction != DirectionBaseline {
+			return true
+		}
+	}
+	return false
+}
+
+// rollsBack reports whether every step of the plan rolls back a migration
+func (plan *Plan) rollsBack() bool {
+	for _, step := range plan.Steps {
+		if step.Direction != DirectionDown {
+			return false
+		}
+	}
+	return len(plan.Steps) > 0
+}
+
+// hooks returns the hooks of a run, the sql callbacks first then the Hooks of the migrator
+func (migrator *Migrator) hooks(db *sql.DB) ([]Hooks, error) {
+	callbacks, err := migrator.newSQLCallbacks(db)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := []Hooks{callbacks}
+	if migrator.Hooks != nil {
+		hooks = append(hooks, migrator.Hooks)
+	}
+	return hooks, nil
+}
+
+// callHooks calls fn on each hook in order, stopping at the first error
+func callHooks(hooks []Hooks, fn func(Hooks) error) error {
+	for _, h := range hooks {
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
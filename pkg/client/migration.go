@@ -2,32 +2,65 @@ package client
 
 import (
 	"fmt"
+	"github.com/hchenc/migrator/pkg/backends"
 	"github.com/hchenc/migrator/pkg/utils"
 	"strings"
+	"time"
 )
 
 type migrationOptions map[string]string
 
+// durationOptions are the options holding a duration such as 30s
+var durationOptions = []string{"timeout", "lock_wait_timeout"}
+
 type MigrationOptions interface {
 	Transaction() bool
+	// Timeout is how long the migration may run, 0 if not set
+	Timeout() time.Duration
+	// LockWaitTimeout is how long each statement may wait for a lock, 0 if not set
+	LockWaitTimeout() time.Duration
+	// Placeholders reports whether ${name} placeholders are replaced, unless placeholders:false
+	Placeholders() bool
 }
 
 func (m migrationOptions) Transaction() bool {
 	return m["transaction"] != "false"
 }
 
+func (m migrationOptions) Placeholders() bool {
+	return m["placeholders"] != "false"
+}
+
+func (m migrationOptions) Timeout() time.Duration {
+	return m.duration("timeout")
+}
+
+func (m migrationOptions) LockWaitTimeout() time.Duration {
+	return m.duration("lock_wait_timeout")
+}
+
+// duration returns the value of a duration option, validated by parseMigrationOptions
+func (m migrationOptions) duration(key string) time.Duration {
+	d, _ := time.ParseDuration(m[key])
+	return d
+}
+
 type Migration struct {
 	Contents string
 	Options  MigrationOptions
+	// Line is the line of the migration file the block starts on
+	Line int
+	// Func runs a go migration instead of Contents
+	Func func(backends.Transaction) error
 }
 
 func NewMigration() Migration {
 	return Migration{Contents: "", Options: make(migrationOptions)}
 }
 
-func parseMigrationContents(contents string) (Migration, Migration, error) {
-	up := NewMigration()
-	down := NewMigration()
+func parseMigrationContents(contents string) (up Migration, down Migration, err error) {
+	up = NewMigration()
+	down = NewMigration()
 
 	upDirectiveStart, upDirectiveEnd, hasDefinedUpBlock := utils.GetMatchPositions(contents, utils.UpRegExp)
 	downDirectiveStart, downDirectiveEnd, hasDefinedDownBlock := utils.GetMatchPositions(contents, utils.DownRegExp)
@@ -52,16 +85,24 @@ func parseMigrationContents(contents string) (Migration, Migration, error) {
 	upDirective := utils.Substring(contents, upDirectiveStart, upDirectiveEnd)
 	downDirective := utils.Substring(contents, downDirectiveStart, downDirectiveEnd)
 
-	up.Options = parseMigrationOptions(upDirective)
+	if up.Options, err = parseMigrationOptions(upDirective); err != nil {
+		return up, down, err
+	}
 	up.Contents = utils.Substring(contents, upDirectiveStart, upEnd)
+	up.Line = strings.Count(contents[:upDirectiveStart], "\n") + 1
 
-	down.Options = parseMigrationOptions(downDirective)
+	if down.Options, err = parseMigrationOptions(downDirective); err != nil {
+		return up, down, err
+	}
 	down.Contents = utils.Substring(contents, downDirectiveStart, downEnd)
+	if hasDefinedDownBlock {
+		down.Line = strings.Count(contents[:downDirectiveStart], "\n") + 1
+	}
 
 	return up, down, nil
 }
 
-func parseMigrationOptions(contents string) MigrationOptions {
+func parseMigrationOptions(contents string) (MigrationOptions, error) {
 	options := make(migrationOptions)
 
 	// strip away the -- migrate:[up|down] part
@@ -72,7 +113,7 @@ func parseMigrationOptions(contents string) MigrationOptions {
 
 	// return empty options if nothing is left to parse
 	if contents == "" {
-		return options
+		return options, nil
 	}
 
 	// split the options string into pairs, e.g. "transaction:false foo:bar" -> []string{"transaction:false", "foo:bar"}
@@ -88,5 +129,14 @@ func parseMigrationOptions(contents string) MigrationOptions {
 		}
 	}
 
-	return options
+	// durations such as timeout:30s must be positive
+	for _, key := range durationOptions {
+		if value, ok := options[key]; ok {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				return options, fmt.Errorf("invalid %s `%s`, expected a positive duration such as 30s", key, value)
+			}
+		}
+	}
+
+	return options, nil
 }
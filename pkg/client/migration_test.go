@@ -0,0 +1,109 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMigrationContentsOptions(t *testing.T) {
+	contents := `-- migrate:up timeout:30s lock_wait_timeout:5s
+create table a (id int);
+
+-- migrate:down transaction:false timeout:1m
+drop table a;
+`
+	up, down, err := parseMigrationContents(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := up.Options.Timeout(); got != 30*time.Second {
+		t.Errorf("up timeout = %s, want 30s", got)
+	}
+	if got := up.Options.LockWaitTimeout(); got != 5*time.Second {
+		t.Errorf("up lock_wait_timeout = %s, want 5s", got)
+	}
+	if strings.Contains(up.Contents, "drop table a") {
+		t.Errorf("up block contains the down block: %q", up.Contents)
+	}
+
+	if down.Options.Transaction() {
+		t.Error("down transaction = true, want false")
+	}
+	if got := down.Options.Timeout(); got != time.Minute {
+		t.Errorf("down timeout = %s, want 1m", got)
+	}
+	if !strings.Contains(down.Contents, "drop table a") {
+		t.Errorf("down block is missing its statement: %q", down.Contents)
+	}
+	if down.Line != 4 {
+		t.Errorf("down line = %d, want 4", down.Line)
+	}
+}
+
+func TestParseMigrationContentsInvalidOption(t *testing.T) {
+	for _, directive := range []string{
+		"-- migrate:up lock_wait_timeout:bogus",
+		"-- migrate:up timeout:30s lock_wait_timeout:bogus",
+		"-- migrate:up transaction:false timeout:-1s",
+	} {
+		if _, _, err := parseMigrationContents(directive + "\nselect 1;\n"); err == nil {
+			t.Errorf("%q: expected an error", directive)
+		}
+	}
+}
+
+func TestParseMigrationOptions(t *testing.T) {
+	tests := []struct {
+		directive string
+		want      map[string]string
+	}{
+		{"-- migrate:up", map[string]string{}},
+		{"--migrate:down   ", map[string]string{}},
+		{"-- migrate:up transaction:false", map[string]string{"transaction": "false"}},
+		{"-- migrate:down timeout:30s lock_wait_timeout:5s", map[string]string{"timeout": "30s", "lock_wait_timeout": "5s"}},
+		{"-- migrate:up  transaction:false\tfoo:bar malformed", map[string]string{"transaction": "false", "foo": "bar"}},
+	}
+
+	for _, test := range tests {
+		options, err := parseMigrationOptions(test.directive)
+		if err != nil {
+			t.Errorf("%q: %s", test.directive, err)
+			continue
+		}
+		got := options.(migrationOptions)
+		if len(got) != len(test.want) {
+			t.Errorf("%q: options = %v, want %v", test.directive, got, test.want)
+			continue
+		}
+		for key, value := range test.want {
+			if got[key] != value {
+				t.Errorf("%q: %s = %q, want %q", test.directive, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestParseMigrationContentsPlaceholders(t *testing.T) {
+	contents := `-- migrate:up transaction:false placeholders:false
+create function f() returns text as $$ select '${literal}' $$ language sql;
+
+-- migrate:down timeout:30s placeholders:false
+drop function f;
+`
+	up, down, err := parseMigrationContents(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if up.Options.Transaction() || up.Options.Placeholders() {
+		t.Errorf("up options = %v, want transaction and placeholders disabled", up.Options)
+	}
+	if down.Options.Placeholders() || down.Options.Timeout() != 30*time.Second {
+		t.Errorf("down options = %v, want placeholders disabled and a 30s timeout", down.Options)
+	}
+	if strings.Contains(up.Contents, "drop function f") {
+		t.Errorf("up block contains the down block: %q", up.Contents)
+	}
+}
@@ -1,17 +1,21 @@
 package client
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/hchenc/migrator/pkg/backends"
 	"github.com/hchenc/migrator/pkg/constants"
 	"github.com/hchenc/migrator/pkg/drivers"
 	_ "github.com/hchenc/migrator/pkg/drivers"
+	merrors "github.com/hchenc/migrator/pkg/errors"
 	"github.com/hchenc/migrator/pkg/utils"
 	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -23,25 +27,57 @@ type Migrator struct {
 	MigrationsLocation string
 	MigrationsTable    string
 	Verbose            bool
-	Log                io.Writer
+	// AllowModified only warns about applied migrations whose checksum changed
+	AllowModified bool
+	// DryRun only prints the migrations which would be executed
+	DryRun bool
+	// LockTimeout is how long to wait for another migrator to finish, forever if not positive
+	LockTimeout time.Duration
+	// MigrationTimeout is how long a migration may run unless it sets the timeout option,
+	// no limit if not positive
+	MigrationTimeout time.Duration
+	// LockWaitTimeout is how long statements wait for table and row locks unless the migration
+	// sets the lock_wait_timeout option, the database default if not positive
+	LockWaitTimeout time.Duration
+	// Retry runs transactional migrations again when they fail with a transient error
+	Retry RetryPolicy
+	// Hooks are called around the migrations, after the sql callback files of MigrationsLocation
+	Hooks Hooks
+	// Placeholders are the values of the ${name} placeholders of the migrations
+	Placeholders map[string]string
+	// OutOfOrder is the policy for pending migrations older than the latest applied one:
+	// allow, warn or fail
+	OutOfOrder string
+	Log        io.Writer
+	// Source provides the migration files, read from MigrationsLocation when nil
+	Source MigrationSource
+	// goMigrations are the go migrations by version, see RegisterGoMigration
+	goMigrations map[string]GoMigration
+	// missingDatabase is set by a dry run CreateIfMissing which did not create the database
+	missingDatabase bool
 }
 
 type StatusResult struct {
 	Filename string
 	Applied  bool
+	// OutOfOrder means the migration is pending but older than the latest applied one
+	OutOfOrder bool
+	// Record holds the migrations table row of an applied migration
+	Record backends.MigrationRecord
 }
 
-func (migrator *Migrator) New(name string) error {
+// New creates a migration file from the template and returns its path
+func (migrator *Migrator) New(name string) (string, error) {
 	// new migration name
 	timestamp := time.Now().UTC().Format("20060102150405")
 	if name == "" {
-		return fmt.Errorf("please specify a name for the new migration")
+		return "", fmt.Errorf("please specify a name for the new migration")
 	}
 	name = fmt.Sprintf("%s_%s.sql", timestamp, name)
 
 	// create migrations dir if missing
 	if err := utils.EnsureDir(migrator.MigrationsLocation); err != nil {
-		return err
+		return "", err
 	}
 
 	// check file does not already exist
@@ -49,32 +85,41 @@ func (migrator *Migrator) New(name string) error {
 	fmt.Fprintf(migrator.Log, "Creating migration: %s\n", path)
 
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		return fmt.Errorf("file already exists")
+		return "", fmt.Errorf("file already exists")
 	}
 
 	// write new migration
 	file, err := os.Create(path)
 	defer file.Close()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	_, err = file.WriteString(constants.MigrationTemplate)
-	return err
+	if _, err = file.WriteString(constants.MigrationTemplate); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
-func NewMigratorClient(databaseUrl *url.URL, user, pass, location, table string, log io.Writer, dump bool) *Migrator {
+func NewMigratorClient(databaseUrl *url.URL, user, pass, location, table string, log io.Writer, dump bool) (*Migrator, error) {
 	migrator := &Migrator{
 		AutoDumpSchema:     dump,
+		SchemaFile:         constants.DefaultSchemaFile,
+		LockTimeout:        constants.DefaultLockTimeout,
+		OutOfOrder:         constants.DefaultOutOfOrder,
+		Retry:              RetryPolicy{MaxAttempts: constants.DefaultRetryAttempts, Backoff: constants.DefaultRetryBackoff},
 		DatabaseUrl:        databaseUrl,
 		MigrationsLocation: location,
 		MigrationsTable:    table,
-		Log:                os.Stdout,
+		Log:                log,
 	}
 	if databaseUrl == nil || databaseUrl.Scheme == "" {
-		panic("invalid url")
+		return nil, merrors.ErrInvalidUrl
+	}
+	generator, ok := drivers.DriverMap[drivers.DriverType(databaseUrl.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("%w `%s`", merrors.ErrUnknownDriver, databaseUrl.Scheme)
 	}
-	generator := drivers.DriverMap[drivers.DriverType(databaseUrl.Scheme)]
 	driver := generator(&backends.BackendConfig{
 		DatabaseUrl:     databaseUrl,
 		DatabaseUser:    user,
@@ -83,219 +128,776 @@ func NewMigratorClient(databaseUrl *url.URL, user, pass, location, table string,
 		Log:             log,
 	})
 	migrator.backend = drivers.NewBackendService(driver)
-	return migrator
+	return migrator, nil
+}
+
+func (migrator *Migrator) Create() error {
+	return migrator.CreateContext(context.Background())
+}
+
+// CreateContext is Create with a context
+func (migrator *Migrator) CreateContext(ctx context.Context) error {
+	fmt.Fprintf(migrator.Log, "Creating: %s\n", utils.GetSchameName(migrator.DatabaseUrl))
+	return migrator.backend.CreateDatabase(ctx)
 }
 
-func (migrator *Migrator) Migrate() error {
-	return migrator.migrate(0)
+func (migrator *Migrator) Drop() error {
+	return migrator.DropContext(context.Background())
 }
 
-func (migrator *Migrator) Status(quiet bool) (int, error) {
-	results, err := migrator.CheckMigrationsStatus()
+// DropContext is Drop with a context
+func (migrator *Migrator) DropContext(ctx context.Context) error {
+	fmt.Fprintf(migrator.Log, "Dropping: %s\n", utils.GetSchameName(migrator.DatabaseUrl))
+	return migrator.backend.DropDatabase(ctx)
+}
+
+// CreateIfMissing creates the database unless it already exists. In dry run mode it only reports
+// that the database would be created, and the following dry runs plan every migration.
+func (migrator *Migrator) CreateIfMissing() error {
+	return migrator.CreateIfMissingContext(context.Background())
+}
+
+// CreateIfMissingContext is CreateIfMissing with a context
+func (migrator *Migrator) CreateIfMissingContext(ctx context.Context) error {
+	exists, err := migrator.backend.DatabaseExists(ctx)
 	if err != nil {
-		return -1, err
+		return err
+	}
+	if exists {
+		return nil
 	}
-	var totalApplied int
-	var line string
+	if migrator.DryRun {
+		fmt.Fprintf(migrator.Log, "Would create: %s\n", utils.GetSchameName(migrator.DatabaseUrl))
+		migrator.missingDatabase = true
+		return nil
+	}
+	return migrator.CreateContext(ctx)
+}
+
+func (migrator *Migrator) Migrate() (*RunResult, error) {
+	return migrator.MigrateContext(context.Background())
+}
+
+// MigrateContext is Migrate with a context, cancelling it stops before the next migration
+// and rolls back the one being applied when it runs in a transaction
+func (migrator *Migrator) MigrateContext(ctx context.Context) (*RunResult, error) {
+	return migrator.migrate(ctx, 0)
+}
+
+// Status returns the applied and pending migrations
+func (migrator *Migrator) Status() (*StatusReport, error) {
+	return migrator.StatusContext(context.Background())
+}
 
+// StatusContext is Status with a context
+func (migrator *Migrator) StatusContext(ctx context.Context) (*StatusReport, error) {
+	results, err := migrator.CheckMigrationsStatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StatusReport{Applied: []MigrationResult{}, Pending: []MigrationResult{}}
 	for _, res := range results {
 		if res.Applied {
-			line = fmt.Sprintf("[V] %s", res.Filename)
-			totalApplied++
+			report.Applied = append(report.Applied, newMigrationResult(res.Filename, res.Record))
+		} else if res.Record.Version != "" {
+			// a repeatable migration changed since it was applied
+			report.Pending = append(report.Pending, newMigrationResult(res.Filename, res.Record))
 		} else {
-			line = fmt.Sprintf("[X] %s", res.Filename)
-		}
-		if !quiet {
-			fmt.Fprintln(migrator.Log, line)
+			pending := newPendingResult(res.Filename)
+			pending.OutOfOrder = res.OutOfOrder
+			report.Pending = append(report.Pending, pending)
 		}
 	}
 
-	totalPending := len(results) - totalApplied
-	if !quiet {
-		fmt.Fprintln(migrator.Log)
-		fmt.Fprintf(migrator.Log, "Applied: %d\n", totalApplied)
-		fmt.Fprintf(migrator.Log, "Pending: %d\n", totalPending)
-	}
+	return report, nil
+}
 
-	return totalPending, nil
+func (migrator *Migrator) Up(step uint) (*RunResult, error) {
+	return migrator.UpContext(context.Background(), step)
 }
 
-func (migrator *Migrator) Up(step uint) error {
-	return migrator.migrate(int(step))
+// UpContext is Up with a context
+func (migrator *Migrator) UpContext(ctx context.Context, step uint) (*RunResult, error) {
+	return migrator.migrate(ctx, int(step))
 }
 
 func (migrator *Migrator) CheckMigrationsStatus() ([]StatusResult, error) {
-	files := utils.MustFindMigrationFiles(migrator.MigrationsLocation, utils.MigrationFileRegexp)
+	return migrator.CheckMigrationsStatusContext(context.Background())
+}
 
-	sqlDB, err := migrator.openDatabaseForMigration()
-	defer sqlDB.Close()
+// CheckMigrationsStatusContext is CheckMigrationsStatus with a context
+func (migrator *Migrator) CheckMigrationsStatusContext(ctx context.Context) ([]StatusResult, error) {
+	files, err := migrator.findMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := migrator.openDatabaseForMigration(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer sqlDB.Close()
 
-	applied, err := migrator.backend.SelectMigrations(sqlDB, -1)
+	applied, err := migrator.backend.SelectMigrations(ctx, sqlDB, -1)
 	if err != nil {
 		return nil, err
 	}
 
 	var results []StatusResult
 
+	latest := latestVersion(applied)
 	for _, filename := range files {
 		ver := utils.MigrationVersion(filename)
 		res := StatusResult{Filename: filename}
-		if ok := applied[ver]; ok {
+		if record, ok := applied[ver]; ok {
 			res.Applied = true
+			res.Record = record
 		} else {
 			res.Applied = false
+			res.OutOfOrder = isOutOfOrder(ver, latest)
 		}
 
 		results = append(results, res)
 	}
 
+	// repeatable migrations are pending until applied with their current contents
+	repeatables, err := migrator.findRepeatableMigrations()
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range repeatables {
+		up, _, err := migrator.parseMigration(filename)
+		if err != nil {
+			return nil, err
+		}
+		record, ok := applied[utils.MigrationVersion(filename)]
+		results = append(results, StatusResult{
+			Filename: filename,
+			Applied:  ok && record.Checksum == migrationChecksum(up),
+			Record:   record,
+		})
+	}
+
 	return results, nil
 }
 
-func (migrator *Migrator) migrate(step int) error {
-	files := utils.MustFindMigrationFiles(migrator.MigrationsLocation, utils.MigrationFileRegexp)
+// History returns the applied migrations, in the order they were applied
+func (migrator *Migrator) History() ([]MigrationResult, error) {
+	return migrator.HistoryContext(context.Background())
+}
 
-	sqlDB, err := migrator.openDatabaseForMigration()
+// HistoryContext is History with a context
+func (migrator *Migrator) HistoryContext(ctx context.Context) ([]MigrationResult, error) {
+	sqlDB, err := migrator.openDatabaseForMigration(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer sqlDB.Close()
+
+	applied, err := migrator.backend.SelectMigrations(ctx, sqlDB, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]backends.MigrationRecord, 0, len(applied))
+	for _, record := range applied {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].AppliedAt.Equal(records[j].AppliedAt) {
+			return records[i].AppliedAt.Before(records[j].AppliedAt)
+		}
+		return utils.CompareVersions(records[i].Version, records[j].Version) < 0
+	})
+
+	history := []MigrationResult{}
+	for _, record := range records {
+		filename, err := migrator.findMigration(record.Version)
+		if err != nil {
+			// the file may have been removed since
+			filename = ""
+		}
+		history = append(history, newMigrationResult(filename, record))
+	}
+	return history, nil
+}
+
+// Validate checks that applied migration files were not modified since they were applied
+func (migrator *Migrator) Validate() error {
+	return migrator.ValidateContext(context.Background())
+}
+
+// ValidateContext is Validate with a context
+func (migrator *Migrator) ValidateContext(ctx context.Context) error {
+	files, err := migrator.findMigrations()
 	if err != nil {
 		return err
 	}
 
-	applied, err := migrator.backend.SelectMigrations(sqlDB, -1)
+	sqlDB, err := migrator.openDatabaseForMigration(ctx)
 	if err != nil {
 		return err
 	}
+	defer sqlDB.Close()
 
-	if step == 0 {
-	} else if slice := len(applied) + step; slice < len(files) {
-		files = files[0:slice]
+	applied, err := migrator.backend.SelectMigrations(ctx, sqlDB, -1)
+	if err != nil {
+		return err
 	}
 
+	return migrator.validate(files, applied)
+}
+
+func (migrator *Migrator) validate(files []string, applied map[string]backends.MigrationRecord) error {
+	var modified []string
 	for _, filename := range files {
-		ver := utils.MigrationVersion(filename)
-		if ok := applied[ver]; ok {
-			// migration already applied
+		record, ok := applied[utils.MigrationVersion(filename)]
+		if !ok || record.Checksum == "" {
+			// pending, or applied before checksums were recorded
 			continue
 		}
 
-		fmt.Fprintf(migrator.Log, "Try to migrate: %s\n", filename)
-
-		up, _, err := parseMigration(filepath.Join(migrator.MigrationsLocation, filename))
+		up, _, err := migrator.parseMigration(filename)
 		if err != nil {
 			return err
 		}
 
-		execMigration := func(tx backends.Transaction) error {
-			// run actual migration
-			result, err := tx.Exec(up.Contents)
-			if err != nil {
-				return err
-			} else if migrator.Verbose {
-				migrator.printVerbose(result)
-			}
+		if migrationChecksum(up) != record.Checksum {
+			fmt.Fprintf(migrator.Log, "Modified: %s\n", filename)
+			modified = append(modified, filename)
+		}
+	}
+
+	if len(modified) > 0 {
+		return &merrors.ChecksumError{Filenames: modified}
+	}
+	return nil
+}
+
+func (migrator *Migrator) migrate(ctx context.Context, step int) (*RunResult, error) {
+	return migrator.run(ctx, migrator.planUp(step))
+}
+
+func (migrator *Migrator) Rollback() (*RunResult, error) {
+	return migrator.RollbackContext(context.Background())
+}
+
+// RollbackContext is Rollback with a context
+func (migrator *Migrator) RollbackContext(ctx context.Context) (*RunResult, error) {
+	return migrator.down(ctx, 1)
+}
+
+func (migrator *Migrator) Down(step uint) (*RunResult, error) {
+	return migrator.DownContext(context.Background(), step)
+}
+
+// DownContext is Down with a context
+func (migrator *Migrator) DownContext(ctx context.Context, step uint) (*RunResult, error) {
+	return migrator.down(ctx, int(step))
+}
 
-			// record migration
-			return migrator.backend.InsertMigration(tx, ver)
+func (migrator *Migrator) down(ctx context.Context, step int) (*RunResult, error) {
+	return migrator.run(ctx, migrator.planDown(step))
+}
+
+// Goto applies or rolls back exactly the migrations needed to land on version,
+// version 0 rolls back every migration
+func (migrator *Migrator) Goto(version string) (*RunResult, error) {
+	return migrator.GotoContext(context.Background(), version)
+}
+
+// GotoContext is Goto with a context
+func (migrator *Migrator) GotoContext(ctx context.Context, version string) (*RunResult, error) {
+	return migrator.run(ctx, migrator.planGoto(version))
+}
+
+// Baseline records every migration up to and including version as applied without running it,
+// to adopt a database created before the migrator was used
+func (migrator *Migrator) Baseline(version string) (*RunResult, error) {
+	return migrator.BaselineContext(context.Background(), version)
+}
+
+// BaselineContext is Baseline with a context
+func (migrator *Migrator) BaselineContext(ctx context.Context, version string) (*RunResult, error) {
+	return migrator.run(ctx, migrator.planBaseline(version))
+}
+
+// Redo rolls back the step most recent migrations then applies them again in a single run,
+// reading their files again so that edits are picked up
+func (migrator *Migrator) Redo(step uint) (*RunResult, error) {
+	return migrator.RedoContext(context.Background(), step)
+}
+
+// RedoContext is Redo with a context
+func (migrator *Migrator) RedoContext(ctx context.Context, step uint) (*RunResult, error) {
+	return migrator.run(ctx, migrator.planRedo(int(step)))
+}
+
+// run executes the plan built by planner, or only prints it in dry run mode.
+// The result lists the migrations executed before a failure too.
+func (migrator *Migrator) run(ctx context.Context, planner planner) (*RunResult, error) {
+	plan, executed, err := migrator.execute(ctx, planner, !migrator.DryRun)
+	result := &RunResult{DryRun: migrator.DryRun, Migrations: executed}
+	if plan != nil {
+		result.Target = plan.Target
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if migrator.DryRun {
+		plan.Print(migrator.Log, true)
+		for _, step := range plan.Steps {
+			result.Migrations = append(result.Migrations, step.result())
 		}
+	}
+	return result, nil
+}
 
-		if up.Options.Transaction() {
-			// begin transaction
-			err = doTransaction(sqlDB, execMigration)
-		} else {
-			// run outside of transaction
-			err = execMigration(sqlDB)
+// execute builds a plan against the current database state and runs it if apply is set,
+// otherwise neither the database nor the migrations table are modified.
+// It returns the executed migrations, up to and including the failing one.
+func (migrator *Migrator) execute(ctx context.Context, planner planner, apply bool) (*Plan, []MigrationResult, error) {
+	files, err := migrator.findMigrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !apply && migrator.missingDatabase {
+		// the database a dry run would create has no applied migration
+		plan, err := migrator.buildPlan(planner, files, map[string]backends.MigrationRecord{})
+		return plan, []MigrationResult{}, err
+	}
+
+	var sqlDB *sql.DB
+	if apply {
+		var release func()
+		if sqlDB, release, err = migrator.openDatabaseLocked(ctx); err != nil {
+			return nil, nil, err
+		}
+		defer release()
+	} else {
+		if sqlDB, err = migrator.openDatabase(ctx); err != nil {
+			return nil, nil, err
+		}
+		defer sqlDB.Close()
+	}
+
+	applied, err := migrator.selectMigrations(ctx, sqlDB, apply)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := migrator.buildPlan(planner, files, applied)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	executed := []MigrationResult{}
+	if !apply {
+		return plan, executed, nil
+	}
+
+	if plan.Target != "" {
+		plan.Print(migrator.Log, false)
+	}
+
+	var hooks []Hooks
+	if plan.hooked() {
+		if hooks, err = migrator.hooks(sqlDB); err != nil {
+			return plan, executed, err
 		}
+	}
+	rollback := plan.rollsBack()
+
+	if err := callHooks(hooks, func(h Hooks) error {
+		if rollback {
+			return h.BeforeRollback(ctx, plan)
+		}
+		return h.BeforeMigrate(ctx, plan)
+	}); err != nil {
+		return plan, executed, err
+	}
 
+	for _, step := range plan.Steps {
+		// stop between migrations once cancelled
+		if err := ctx.Err(); err != nil {
+			return plan, executed, err
+		}
+
+		if err := callHooks(hooks, func(h Hooks) error { return h.BeforeEach(ctx, step) }); err != nil {
+			return plan, executed, err
+		}
+
+		res := step.result()
+		start := time.Now()
+		err = migrator.executeStep(ctx, sqlDB, step)
+		res.Duration = Duration(time.Since(start))
 		if err != nil {
-			return err
+			res.Error = err.Error()
+			for _, h := range hooks {
+				h.OnError(ctx, step, err)
+			}
+			return plan, append(executed, res), err
+		}
+		executed = append(executed, res)
+
+		if err := callHooks(hooks, func(h Hooks) error { return h.AfterEach(ctx, res) }); err != nil {
+			return plan, executed, err
+		}
+	}
+
+	if err := callHooks(hooks, func(h Hooks) error {
+		if rollback {
+			return h.AfterRollback(ctx, executed)
 		}
+		return h.AfterMigrate(ctx, executed)
+	}); err != nil {
+		return plan, executed, err
+	}
+
+	migrator.autoDumpSchema(ctx)
+
+	return plan, executed, nil
+}
+
+// executeStep runs a step of the plan, cancelled once its timeout elapses
+// buildPlan runs planner once the applied migrations passed the pre-flight checks
+func (migrator *Migrator) buildPlan(planner planner, files []string, applied map[string]backends.MigrationRecord) (*Plan, error) {
+	if err := checkDirty(applied); err != nil {
+		return nil, err
+	}
+
+	plan, err := planner(files, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrator.check(files, applied, plan); err != nil {
+		return nil, err
 	}
+	return plan, nil
+}
 
-	// automatically update schema file, silence errors
-	if migrator.AutoDumpSchema {
-		_ = migrator.dumpSchema()
+func (migrator *Migrator) executeStep(ctx context.Context, sqlDB *sql.DB, step PlanStep) error {
+	if timeout := migrator.timeout(step.migration); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
+	switch step.Direction {
+	case DirectionUp:
+		return migrator.applyMigration(ctx, sqlDB, step)
+	case DirectionDown:
+		return migrator.rollbackMigration(ctx, sqlDB, step)
+	case DirectionBaseline:
+		return migrator.baselineMigration(ctx, sqlDB, step)
+	}
 	return nil
 }
 
-func (migrator *Migrator) Rollback() error {
-	return migrator.down(1)
+// timeout returns how long a migration may run, its timeout option taking precedence
+func (migrator *Migrator) timeout(migration Migration) time.Duration {
+	if timeout := migration.Options.Timeout(); timeout > 0 {
+		return timeout
+	}
+	return migrator.MigrationTimeout
 }
 
-func (migrator *Migrator) Down(step uint) error {
-	return migrator.down(int(step))
+// lockWaitTimeout returns how long the statements of a migration wait for locks,
+// its lock_wait_timeout option taking precedence
+func (migrator *Migrator) lockWaitTimeout(migration Migration) time.Duration {
+	if timeout := migration.Options.LockWaitTimeout(); timeout > 0 {
+		return timeout
+	}
+	return migrator.LockWaitTimeout
 }
 
-func (migrator *Migrator) down(step int) error {
-	sqlDB, err := migrator.openDatabaseForMigration()
-	defer sqlDB.Close()
-	if err != nil {
-		return err
+// applyMigration runs the up block of a migration file and records it
+func (migrator *Migrator) applyMigration(ctx context.Context, sqlDB *sql.DB, step PlanStep) error {
+	fmt.Fprintf(migrator.Log, "Try to migrate: %s\n", step.Filename)
+
+	up := step.migration
+	// a repeatable migration which changed since it was applied already has a record
+	reapply := step.record.Version != ""
+	record := backends.MigrationRecord{
+		Version:     step.Version,
+		Description: utils.MigrationDescription(step.Filename),
+		Checksum:    migrationChecksum(up),
+		AppliedAt:   time.Now().UTC(),
+		AppliedBy:   utils.CurrentUser(),
+		Hostname:    utils.Hostname(),
 	}
-	for s := 0; s < step; s++ {
-		applied, err := migrator.backend.SelectMigrations(sqlDB, 1)
-		// grab most recent applied migration (applied has len=1)
-		latest := ""
-		for ver := range applied {
-			latest = ver
+
+	execMigration := func(tx backends.Transaction) error {
+		// run actual migration
+		start := time.Now()
+		if err := migrator.execStatements(ctx, sqlDB, tx, step); err != nil {
+			return err
 		}
-		if latest == "" {
-			return fmt.Errorf("can't rollback: no migrations have been applied")
+
+		// record migration
+		record.AppliedAt = start.UTC()
+		record.ExecutionTime = time.Since(start)
+		record.Dirty = false
+		if up.Options.Transaction() && !reapply {
+			return migrator.backend.InsertMigration(ctx, tx, record)
 		}
+		return migrator.backend.UpdateMigration(ctx, tx, record)
+	}
 
-		filename := utils.MustFindMigrationFile(migrator.MigrationsLocation, latest)
+	if up.Options.Transaction() {
+		// begin transaction
+		return migrator.doTransaction(ctx, sqlDB, execMigration)
+	}
 
-		fmt.Fprintf(migrator.Log, "Rolling back: %s\n", filename)
+	// run outside of transaction, flagged dirty until it succeeds
+	record.Dirty = true
+	if reapply {
+		if err := migrator.backend.UpdateMigration(ctx, sqlDB, record); err != nil {
+			return err
+		}
+	} else if err := migrator.backend.InsertMigration(ctx, sqlDB, record); err != nil {
+		return err
+	}
+	return doConnection(ctx, sqlDB, execMigration)
+}
 
-		_, down, err := parseMigration(filepath.Join(migrator.MigrationsLocation, filename))
-		if err != nil {
+// baselineMigration records a migration as applied without running it
+func (migrator *Migrator) baselineMigration(ctx context.Context, sqlDB *sql.DB, step PlanStep) error {
+	fmt.Fprintf(migrator.Log, "Baselining: %s\n", step.Filename)
+
+	return migrator.backend.InsertMigration(ctx, sqlDB, backends.MigrationRecord{
+		Version:     step.Version,
+		Description: utils.MigrationDescription(step.Filename),
+		Checksum:    migrationChecksum(step.migration),
+		AppliedAt:   time.Now().UTC(),
+		AppliedBy:   utils.CurrentUser(),
+		Hostname:    utils.Hostname(),
+	})
+}
+
+// rollbackMigration runs the down block of an applied migration and removes its record
+func (migrator *Migrator) rollbackMigration(ctx context.Context, sqlDB *sql.DB, step PlanStep) error {
+	fmt.Fprintf(migrator.Log, "Rolling back: %s\n", step.Filename)
+
+	down := step.migration
+	record := step.record
+
+	execMigration := func(tx backends.Transaction) error {
+		// rollback migration
+		if err := migrator.execStatements(ctx, sqlDB, tx, step); err != nil {
 			return err
 		}
 
-		execMigration := func(tx backends.Transaction) error {
-			// rollback migration
-			result, err := tx.Exec(down.Contents)
-			if err != nil {
+		// remove migration record
+		return migrator.backend.DeleteMigration(ctx, tx, record.Version)
+	}
+
+	if down.Options.Transaction() {
+		// begin transaction
+		return migrator.doTransaction(ctx, sqlDB, execMigration)
+	}
+
+	// run outside of transaction, flagged dirty until it succeeds
+	record.Dirty = true
+	if err := migrator.backend.UpdateMigration(ctx, sqlDB, record); err != nil {
+		return err
+	}
+	return doConnection(ctx, sqlDB, execMigration)
+}
+
+// execStatements runs the statements of a migration block one by one,
+// within the lock wait timeout of the migration
+func (migrator *Migrator) execStatements(ctx context.Context, sqlDB *sql.DB, tx backends.Transaction, step PlanStep) error {
+	// stop the statements on the server too when interrupted or timed out
+	stop, err := migrator.backend.KillOnCancel(ctx, sqlDB, tx)
+	if err != nil {
+		return &merrors.MigrationError{Filename: step.Filename, Err: err}
+	}
+	defer stop()
+
+	timeout := migrator.lockWaitTimeout(step.migration)
+	if timeout <= 0 {
+		return migrator.runStatements(ctx, tx, step)
+	}
+
+	restore, err := migrator.backend.SetLockWaitTimeout(ctx, tx, timeout)
+	if err != nil {
+		return &merrors.MigrationError{Filename: step.Filename, Err: err}
+	}
+	if err := migrator.runStatements(ctx, tx, step); err != nil {
+		// a failed transaction may refuse any further statement
+		restore()
+		return err
+	}
+	if err := restore(); err != nil {
+		return &merrors.MigrationError{Filename: step.Filename, Err: err}
+	}
+	return nil
+}
+
+// runStatements runs the go function or the statements of a migration block
+func (migrator *Migrator) runStatements(ctx context.Context, tx backends.Transaction, step PlanStep) error {
+	if step.migration.Func != nil {
+		if err := step.migration.Func(tx); err != nil {
+			return &merrors.MigrationError{Filename: step.Filename, Err: migrator.timeoutError(ctx, step, err)}
+		}
+		return nil
+	}
+
+	for i, statement := range step.statements {
+		result, err := tx.ExecContext(ctx, statement.SQL)
+		if err != nil {
+			return &merrors.MigrationError{
+				Filename:  step.Filename,
+				Statement: statement.SQL,
+				Index:     i + 1,
+				Line:      statement.Line,
+				Err:       migrator.timeoutError(ctx, step, err),
+			}
+		} else if migrator.Verbose {
+			migrator.printVerbose(result)
+		}
+	}
+	return nil
+}
+
+// timeoutError replaces the driver error of a statement interrupted by the timeout of the migration
+func (migrator *Migrator) timeoutError(ctx context.Context, step PlanStep, err error) error {
+	timeout := migrator.timeout(step.migration)
+	if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", merrors.ErrMigrationTimeout, timeout)
+	}
+	return err
+}
+
+// check runs the pre-flight checks shared by every command applying migrations
+func (migrator *Migrator) check(files []string, applied map[string]backends.MigrationRecord, plan *Plan) error {
+	// migrations rolled back by the plan may have been edited since, e.g. before a redo
+	validated := make(map[string]backends.MigrationRecord, len(applied))
+	for version, record := range applied {
+		validated[version] = record
+	}
+	for _, step := range plan.Steps {
+		if step.Direction == DirectionDown {
+			delete(validated, step.Version)
+		}
+	}
+
+	// only plans applying migrations are validated, rollbacks must stay possible after an edit
+	if plan.applies() {
+		if err := migrator.validate(files, validated); err != nil {
+			if !migrator.AllowModified || !errors.Is(err, merrors.ErrChecksumMismatch) {
 				return err
-			} else if migrator.Verbose {
-				migrator.printVerbose(result)
 			}
+			fmt.Fprintf(migrator.Log, "Warning: %s\n", err)
+		}
+	}
+
+	return migrator.checkOutOfOrder(plan, applied)
+}
 
-			// remove migration record
-			return migrator.backend.DeleteMigration(tx, latest)
+// checkOutOfOrder applies the out of order policy to the migrations the plan applies
+func (migrator *Migrator) checkOutOfOrder(plan *Plan, applied map[string]backends.MigrationRecord) error {
+	var filenames []string
+	latest := latestVersion(applied)
+	for _, step := range plan.Steps {
+		if _, ok := applied[step.Version]; ok {
+			// re-applied after being rolled back
+			continue
+		}
+		if step.Direction == DirectionUp && isOutOfOrder(step.Version, latest) {
+			filenames = append(filenames, step.Filename)
 		}
+	}
 
-		if down.Options.Transaction() {
-			// begin transaction
-			err = doTransaction(sqlDB, execMigration)
-		} else {
-			// run outside of transaction
-			err = execMigration(sqlDB)
+	switch migrator.OutOfOrder {
+	case constants.OutOfOrderAllow:
+		return nil
+	case constants.OutOfOrderWarn, "":
+		if len(filenames) > 0 {
+			fmt.Fprintf(migrator.Log, "Warning: %s\n", &merrors.OutOfOrderError{Filenames: filenames})
 		}
-		if err != nil {
-			return err
+		return nil
+	case constants.OutOfOrderFail:
+		if len(filenames) > 0 {
+			return &merrors.OutOfOrderError{Filenames: filenames}
 		}
+		return nil
 	}
+	return fmt.Errorf("unknown out of order policy `%s`, expected %s, %s or %s", migrator.OutOfOrder,
+		constants.OutOfOrderAllow, constants.OutOfOrderWarn, constants.OutOfOrderFail)
+}
 
-	// automatically update schema file, silence errors
-	if migrator.AutoDumpSchema {
-		_ = migrator.dumpSchema()
+// latestVersion returns the most recent applied versioned migration, empty if none
+func latestVersion(applied map[string]backends.MigrationRecord) string {
+	records := sortedRecords(applied)
+	if len(records) == 0 {
+		return ""
 	}
+	return records[0].Version
+}
 
-	return nil
+// isOutOfOrder reports whether a pending version is older than the latest applied version
+func isOutOfOrder(version, latest string) bool {
+	return latest != "" && !utils.IsRepeatable(version) && utils.CompareVersions(version, latest) < 0
 }
 
-func (migrator *Migrator) openDatabaseForMigration() (*sql.DB, error) {
-	sqlDB, err := migrator.backend.OpenDatabase()
+// pendingMigrations returns the files which have not been applied yet, in order
+func pendingMigrations(files []string, applied map[string]backends.MigrationRecord) []string {
+	var pending []string
+	for _, filename := range files {
+		if _, ok := applied[utils.MigrationVersion(filename)]; ok {
+			// migration already applied
+			continue
+		}
+		pending = append(pending, filename)
+	}
+	return pending
+}
+
+// autoDumpSchema updates the schema file when enabled, reporting but not failing on errors
+func (migrator *Migrator) autoDumpSchema(ctx context.Context) {
+	if !migrator.AutoDumpSchema {
+		return
+	}
+	if err := migrator.dumpSchema(ctx); err != nil {
+		fmt.Fprintf(migrator.Log, "Failed to dump schema: %s\n", err)
+	}
+}
+
+// selectMigrations reads the applied migrations. Without apply the migrations table is neither
+// created nor upgraded, so a missing table has no applied migration and a table created by an
+// older version only has their versions.
+func (migrator *Migrator) selectMigrations(ctx context.Context, sqlDB *sql.DB, apply bool) (map[string]backends.MigrationRecord, error) {
+	if apply {
+		return migrator.backend.SelectMigrations(ctx, sqlDB, -1)
+	}
+
+	columns, err := migrator.backend.MigrationsTableColumns(ctx, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		fmt.Fprintln(migrator.Log, "Migrations table not found, assuming no migration was applied")
+		return map[string]backends.MigrationRecord{}, nil
+	}
+	if drivers.IsLegacyMigrationsTable(columns) {
+		return migrator.backend.SelectMigrationVersions(ctx, sqlDB)
+	}
+	return migrator.backend.SelectMigrations(ctx, sqlDB, -1)
+}
+
+func (migrator *Migrator) openDatabaseForMigration(ctx context.Context) (*sql.DB, error) {
+	sqlDB, err := migrator.openDatabase(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := migrator.backend.CreateMigrationsTable(sqlDB); err != nil {
+	if err := migrator.backend.CreateMigrationsTable(ctx, sqlDB); err != nil {
 		defer sqlDB.Close()
 		return nil, err
 	}
@@ -303,13 +905,198 @@ func (migrator *Migrator) openDatabaseForMigration() (*sql.DB, error) {
 	return sqlDB, nil
 }
 
-func parseMigration(path string) (Migration, Migration, error) {
-	data, err := os.ReadFile(path)
+// openDatabaseLocked connects to the database and takes the migrator lock before creating or
+// upgrading the migrations table, so that concurrent migrators don't race on it.
+// The returned function releases the lock and closes the connection.
+func (migrator *Migrator) openDatabaseLocked(ctx context.Context) (*sql.DB, func(), error) {
+	sqlDB, err := migrator.openDatabase(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock, err := migrator.lock(ctx, sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+	release := func() {
+		unlock()
+		sqlDB.Close()
+	}
+
+	if err := migrator.backend.CreateMigrationsTable(ctx, sqlDB); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return sqlDB, release, nil
+}
+
+// openDatabase connects to the database without creating the migrations table
+func (migrator *Migrator) openDatabase(ctx context.Context) (*sql.DB, error) {
+	sqlDB, err := migrator.backend.OpenDatabase()
+	if err != nil {
+		return nil, &merrors.ConnectionError{Err: err}
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		defer sqlDB.Close()
+		return nil, &merrors.ConnectionError{Err: err}
+	}
+
+	return sqlDB, nil
+}
+
+// Repair marks a dirty version clean after it was fixed manually, or removes it when remove is set
+func (migrator *Migrator) Repair(version string, remove bool) error {
+	return migrator.RepairContext(context.Background(), version, remove)
+}
+
+// RepairContext is Repair with a context
+func (migrator *Migrator) RepairContext(ctx context.Context, version string, remove bool) error {
+	sqlDB, release, err := migrator.openDatabaseLocked(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := migrator.backend.SelectMigrations(ctx, sqlDB, -1)
+	if err != nil {
+		return err
+	}
+
+	record, ok := applied[version]
+	if !ok {
+		return fmt.Errorf("version %s has not been applied", version)
+	}
+	if !record.Dirty {
+		return fmt.Errorf("version %s is not dirty", version)
+	}
+
+	if remove {
+		fmt.Fprintf(migrator.Log, "Removing: %s\n", version)
+		return migrator.backend.DeleteMigration(ctx, sqlDB, version)
+	}
+
+	fmt.Fprintf(migrator.Log, "Marking clean: %s\n", version)
+	record.Dirty = false
+	return migrator.backend.UpdateMigration(ctx, sqlDB, record)
+}
+
+// checkDirty refuses to run while a failed migration left the database partially changed
+func checkDirty(applied map[string]backends.MigrationRecord) error {
+	for _, record := range applied {
+		if record.Dirty {
+			return &merrors.DirtyError{Version: record.Version}
+		}
+	}
+	return nil
+}
+
+// lock acquires the migrations lock so that concurrent migrators can't apply the same migration twice
+func (migrator *Migrator) lock(ctx context.Context, sqlDB *sql.DB) (func(), error) {
+	release, err := migrator.backend.Lock(ctx, sqlDB, migrator.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := release(); err != nil {
+			fmt.Fprintf(migrator.Log, "Failed to release lock: %s\n", err)
+		}
+	}, nil
+}
+
+// migrationChecksum returns the checksum of the up block, empty for go migrations which can't be checked
+func migrationChecksum(up Migration) string {
+	if up.Func != nil {
+		return ""
+	}
+	return utils.Checksum(up.Contents)
+}
+
+// source returns the source of the migration files
+func (migrator *Migrator) source() MigrationSource {
+	if migrator.Source != nil {
+		return migrator.Source
+	}
+	return NewDirSource(migrator.MigrationsLocation)
+}
+
+// findMigrations returns the migration files merged with the go migrations, ordered by version
+func (migrator *Migrator) findMigrations() ([]string, error) {
+	files, err := migrator.source().FindMigrationFiles(utils.MigrationFileRegexp)
+	if err != nil && len(migrator.goMigrations) == 0 {
+		return nil, err
+	}
+
+	versions := map[string]string{}
+	for _, filename := range files {
+		versions[utils.MigrationVersion(filename)] = filename
+	}
+	for version, m := range migrator.goMigrations {
+		if filename, ok := versions[version]; ok {
+			return nil, fmt.Errorf("%w: go migration %s has the same version as %s",
+				merrors.ErrInvalidMigration, m.Filename(), filename)
+		}
+		files = append(files, m.Filename())
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if c := utils.CompareVersions(utils.MigrationVersion(files[i]), utils.MigrationVersion(files[j])); c != 0 {
+			return c < 0
+		}
+		return files[i] < files[j]
+	})
+	return files, nil
+}
+
+// findRepeatableMigrations returns the repeatable migration files, ordered by name
+func (migrator *Migrator) findRepeatableMigrations() ([]string, error) {
+	files, err := migrator.source().FindMigrationFiles(utils.RepeatableMigrationFileRegexp)
+	if errors.Is(err, merrors.ErrNoMigrations) || errors.Is(err, merrors.ErrMigrationsDirectory) {
+		// repeatable migrations are optional
+		return nil, nil
+	}
+	return files, err
+}
+
+// findMigration returns the migration file or go migration of version
+func (migrator *Migrator) findMigration(version string) (string, error) {
+	if m, ok := migrator.goMigrations[version]; ok {
+		return m.Filename(), nil
+	}
+	if utils.IsRepeatable(version) {
+		files, err := migrator.findRepeatableMigrations()
+		if err != nil {
+			return "", err
+		}
+		for _, filename := range files {
+			if utils.MigrationVersion(filename) == version {
+				return filename, nil
+			}
+		}
+		return "", fmt.Errorf("%w for version %s", merrors.ErrNoMigrations, version)
+	}
+	return migrator.source().FindMigrationFile(version)
+}
+
+// parseMigration returns the up and down blocks of a migration file or go migration
+func (migrator *Migrator) parseMigration(filename string) (Migration, Migration, error) {
+	if m, ok := migrator.goMigration(filename); ok {
+		up, down := parseGoMigration(m)
+		return up, down, nil
+	}
+
+	data, err := migrator.source().ReadMigrationFile(filename)
 	if err != nil {
 		return NewMigration(), NewMigration(), err
 	}
 	up, down, err := parseMigrationContents(string(data))
-	return up, down, err
+	if err != nil {
+		return up, down, fmt.Errorf("%w %s: %s", merrors.ErrInvalidMigration, filename, err)
+	}
+	return up, down, nil
 }
 
 func (migrator *Migrator) printVerbose(result sql.Result) {
@@ -323,32 +1110,64 @@ func (migrator *Migrator) printVerbose(result sql.Result) {
 	}
 }
 
-func doTransaction(sqlDB *sql.DB, txFunc func(backends.Transaction) error) error {
-	tx, err := sqlDB.Begin()
+func doTransaction(ctx context.Context, sqlDB *sql.DB, txFunc func(backends.Transaction) error) error {
+	tx, err := sqlDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	if err := txFunc(tx); err != nil {
-		if err1 := tx.Rollback(); err1 != nil {
+		// the transaction is already rolled back when ctx was cancelled
+		if err1 := tx.Rollback(); err1 != nil && !errors.Is(err1, sql.ErrTxDone) {
 			return err1
 		}
 
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return &commitError{err}
+	}
+	return nil
 }
 
-func (migrator *Migrator) dumpSchema() error {
+// connTransaction runs statements on a single connection outside of a transaction
+type connTransaction struct {
+	*sql.Conn
+}
 
-	sqlDB, err := migrator.openDatabaseForMigration()
-	defer sqlDB.Close()
+func (c connTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c connTransaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c connTransaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+// doConnection runs txFunc outside of a transaction on a single connection,
+// so that the session variables it sets apply to all of its statements
+func doConnection(ctx context.Context, sqlDB *sql.DB, txFunc func(backends.Transaction) error) error {
+	conn, err := sqlDB.Conn(ctx)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+
+	return txFunc(connTransaction{conn})
+}
+
+func (migrator *Migrator) dumpSchema(ctx context.Context) error {
+	sqlDB, err := migrator.openDatabaseForMigration(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
 
-	schema, err := migrator.backend.DumpSchema(sqlDB)
+	schema, err := migrator.backend.DumpSchema(ctx, sqlDB)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,680 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/constants"
+	_ "github.com/hchenc/migrator/pkg/drivers/sqlite"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+)
+
+// migration returns the contents of a migration file creating table
+func migration(table string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte("-- migrate:up\ncreate table " + table + " (id int);\n\n-- migrate:down\ndrop table " + table + ";\n")}
+}
+
+// testFiles are three migrations creating the tables a, b and c
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"001_a.sql": migration("a"),
+		"002_b.sql": migration("b"),
+		"003_c.sql": migration("c"),
+	}
+}
+
+// newTestMigrator returns a migrator reading files against an in-memory sqlite database
+func newTestMigrator(t *testing.T, files fstest.MapFS) *Migrator {
+	t.Helper()
+	databaseUrl, err := url.Parse("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg, err := NewMigratorClient(databaseUrl, "", "", "", "schema_history", io.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg.Source = NewFSSource(files, ".")
+	mg.Retry = RetryPolicy{MaxAttempts: 1}
+	return mg
+}
+
+// appliedVersions returns the versions recorded in the migrations table, in order
+func appliedVersions(t *testing.T, mg *Migrator) []string {
+	t.Helper()
+	sqlDB, err := mg.openDatabaseForMigration(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	applied, err := mg.backend.SelectMigrations(context.Background(), sqlDB, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []string{}
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// tables returns the tables of the database besides the migrations table, in order
+func tables(t *testing.T, mg *Migrator) []string {
+	t.Helper()
+	sqlDB, err := mg.openDatabase(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	rows, err := sqlDB.Query("select name from sqlite_master where type = 'table' and name != 'schema_history' order by name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// exec runs statements against the database of mg
+func exec(t *testing.T, mg *Migrator, statements ...string) {
+	t.Helper()
+	sqlDB, err := mg.openDatabase(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	for _, statement := range statements {
+		if _, err := sqlDB.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mustRun fails the test when running migrations failed
+func mustRun(t *testing.T) func(*RunResult, error) {
+	return func(result *RunResult, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func versions(migrations []MigrationResult) []string {
+	result := []string{}
+	for _, m := range migrations {
+		result = append(result, m.Direction+" "+m.Version)
+	}
+	return result
+}
+
+func planned(plan *Plan) []string {
+	result := []string{}
+	for _, step := range plan.Steps {
+		result = append(result, step.Direction+" "+step.Version)
+	}
+	return result
+}
+
+func assertEqual(t *testing.T, name string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func TestMigratorRun(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup applies migrations before the tested operation
+		setup   func(mg *Migrator) error
+		run     func(mg *Migrator) (*RunResult, error)
+		want    []string
+		applied []string
+		tables  []string
+	}{
+		{
+			name:    "migrate",
+			run:     (*Migrator).Migrate,
+			want:    []string{"up 001", "up 002", "up 003"},
+			applied: []string{"001", "002", "003"},
+			tables:  []string{"a", "b", "c"},
+		},
+		{
+			name:    "up",
+			run:     func(mg *Migrator) (*RunResult, error) { return mg.Up(2) },
+			want:    []string{"up 001", "up 002"},
+			applied: []string{"001", "002"},
+			tables:  []string{"a", "b"},
+		},
+		{
+			name:    "rollback",
+			setup:   func(mg *Migrator) error { _, err := mg.Migrate(); return err },
+			run:     (*Migrator).Rollback,
+			want:    []string{"down 003"},
+			applied: []string{"001", "002"},
+			tables:  []string{"a", "b"},
+		},
+		{
+			name:    "down",
+			setup:   func(mg *Migrator) error { _, err := mg.Migrate(); return err },
+			run:     func(mg *Migrator) (*RunResult, error) { return mg.Down(2) },
+			want:    []string{"down 003", "down 002"},
+			applied: []string{"001"},
+			tables:  []string{"a"},
+		},
+		{
+			name:    "goto up",
+			setup:   func(mg *Migrator) error { _, err := mg.Up(1); return err },
+			run:     func(mg *Migrator) (*RunResult, error) { return mg.Goto("002") },
+			want:    []string{"up 002"},
+			applied: []string{"001", "002"},
+			tables:  []string{"a", "b"},
+		},
+		{
+			name:    "goto down",
+			setup:   func(mg *Migrator) error { _, err := mg.Migrate(); return err },
+			run:     func(mg *Migrator) (*RunResult, error) { return mg.Goto("001") },
+			want:    []string{"down 003", "down 002"},
+			applied: []string{"001"},
+			tables:  []string{"a"},
+		},
+		{
+			name:    "redo",
+			setup:   func(mg *Migrator) error { _, err := mg.Migrate(); return err },
+			run:     func(mg *Migrator) (*RunResult, error) { return mg.Redo(2) },
+			want:    []string{"down 003", "down 002", "up 002", "up 003"},
+			applied: []string{"001", "002", "003"},
+			tables:  []string{"a", "b", "c"},
+		},
+		{
+			name:    "baseline",
+			run:     func(mg *Migrator) (*RunResult, error) { return mg.Baseline("002") },
+			want:    []string{"baseline 001", "baseline 002"},
+			applied: []string{"001", "002"},
+			tables:  []string{},
+		},
+		{
+			name: "dry run",
+			run: func(mg *Migrator) (*RunResult, error) {
+				mg.DryRun = true
+				return mg.Migrate()
+			},
+			want:    []string{"up 001", "up 002", "up 003"},
+			applied: []string{},
+			tables:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mg := newTestMigrator(t, testFiles())
+			if test.setup != nil {
+				if err := test.setup(mg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := test.run(mg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mg.DryRun = false
+			assertEqual(t, "executed", versions(result.Migrations), test.want)
+			assertEqual(t, "applied", appliedVersions(t, mg), test.applied)
+			assertEqual(t, "tables", tables(t, mg), test.tables)
+		})
+	}
+}
+
+func TestMigratorPlan(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, mg *Migrator)
+		plan  func(mg *Migrator) (*Plan, error)
+		want  []string
+	}{
+		{
+			name: "pending migrations",
+			setup: func(t *testing.T, mg *Migrator) {
+				mustRun(t)(mg.Up(1))
+			},
+			plan: (*Migrator).Plan,
+			want: []string{"up 002", "up 003"},
+		},
+		{
+			name: "missing migrations table",
+			plan: (*Migrator).Plan,
+			want: []string{"up 001", "up 002", "up 003"},
+		},
+		{
+			name: "legacy migrations table",
+			setup: func(t *testing.T, mg *Migrator) {
+				exec(t, mg, "create table schema_history (version varchar(255) primary key)",
+					"insert into schema_history (version) values ('001')")
+			},
+			plan: (*Migrator).Plan,
+			want: []string{"up 002", "up 003"},
+		},
+		{
+			name: "down",
+			setup: func(t *testing.T, mg *Migrator) {
+				mustRun(t)(mg.Migrate())
+			},
+			plan: func(mg *Migrator) (*Plan, error) { return mg.PlanDown(2) },
+			want: []string{"down 003", "down 002"},
+		},
+		{
+			name: "goto",
+			setup: func(t *testing.T, mg *Migrator) {
+				mustRun(t)(mg.Migrate())
+			},
+			plan: func(mg *Migrator) (*Plan, error) { return mg.PlanGoto("001") },
+			want: []string{"down 003", "down 002"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mg := newTestMigrator(t, testFiles())
+			if test.setup != nil {
+				test.setup(t, mg)
+			}
+			before := tables(t, mg)
+
+			plan, err := test.plan(mg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, "plan", planned(plan), test.want)
+			assertEqual(t, "tables", tables(t, mg), before)
+		})
+	}
+}
+
+func TestMigratorDirtyRepair(t *testing.T) {
+	files := testFiles()
+	files["002_b.sql"] = &fstest.MapFile{Data: []byte("-- migrate:up transaction:false\ncreate table b (id int);\ninsert into missing values (1);\n\n-- migrate:down\ndrop table b;\n")}
+
+	tests := []struct {
+		name    string
+		remove  bool
+		applied []string
+	}{
+		{name: "mark clean", applied: []string{"001", "002"}},
+		{name: "remove", remove: true, applied: []string{"001"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mg := newTestMigrator(t, files)
+			if _, err := mg.Migrate(); !errors.Is(err, merrors.ErrMigrationFailed) {
+				t.Fatalf("Migrate() error = %v, want a failed migration", err)
+			}
+			if _, err := mg.Migrate(); !errors.Is(err, merrors.ErrDirty) {
+				t.Fatalf("Migrate() error = %v, want a dirty database", err)
+			}
+			if err := mg.Repair("001", test.remove); err == nil {
+				t.Error("Repair() of a clean version succeeded")
+			}
+
+			if err := mg.Repair("002", test.remove); err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(t, "applied", appliedVersions(t, mg), test.applied)
+			if _, err := mg.Plan(); err != nil {
+				t.Errorf("Plan() after repair: %s", err)
+			}
+		})
+	}
+}
+
+func TestMigratorOutOfOrder(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr error
+		want    []string
+	}{
+		{policy: constants.OutOfOrderAllow, want: []string{"up 002"}},
+		{policy: constants.OutOfOrderWarn, want: []string{"up 002"}},
+		{policy: constants.OutOfOrderFail, wantErr: merrors.ErrOutOfOrder},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			files := testFiles()
+			pending := files["002_b.sql"]
+			delete(files, "002_b.sql")
+			mg := newTestMigrator(t, files)
+			mustRun(t)(mg.Migrate())
+
+			files["002_b.sql"] = pending
+			mg.OutOfOrder = test.policy
+			result, err := mg.Migrate()
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Migrate() error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr == nil {
+				assertEqual(t, "executed", versions(result.Migrations), test.want)
+			}
+		})
+	}
+}
+
+func TestMigratorRepeatable(t *testing.T) {
+	files := testFiles()
+	files["R__views.sql"] = &fstest.MapFile{Data: []byte("-- migrate:up\ndrop view if exists v;\ncreate view v as select id from a;\n")}
+	mg := newTestMigrator(t, files)
+
+	result, err := mg.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "executed", versions(result.Migrations), []string{"up 001", "up 002", "up 003", "up R__views"})
+
+	result, err = mg.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "executed unchanged", versions(result.Migrations), []string{})
+
+	files["R__views.sql"] = &fstest.MapFile{Data: []byte("-- migrate:up\ndrop view if exists v;\ncreate view v as select id from b;\n")}
+	result, err = mg.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "executed changed", versions(result.Migrations), []string{"up R__views"})
+
+	// repeatable migrations are never rolled back
+	result, err = mg.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "rolled back", versions(result.Migrations), []string{"down 003"})
+}
+
+func TestMigratorGoMigration(t *testing.T) {
+	mg := newTestMigrator(t, testFiles())
+	up := func(tx backends.Transaction) error {
+		_, err := tx.Exec("create table go (id int)")
+		return err
+	}
+	down := func(tx backends.Transaction) error {
+		_, err := tx.Exec("drop table go")
+		return err
+	}
+	if err := mg.RegisterGoMigration("004", "go", up, down); err != nil {
+		t.Fatal(err)
+	}
+	if err := mg.RegisterGoMigration("002", "clash", up, down); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mg.Migrate(); !errors.Is(err, merrors.ErrInvalidMigration) {
+		t.Fatalf("Migrate() error = %v, want a version clash", err)
+	}
+	delete(mg.goMigrations, "002")
+
+	if err := mg.RegisterGoMigration("R__go", "invalid", up, down); err == nil {
+		t.Error("RegisterGoMigration() accepted a non numeric version")
+	}
+
+	result, err := mg.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "executed", versions(result.Migrations), []string{"up 001", "up 002", "up 003", "up 004"})
+	assertEqual(t, "tables", tables(t, mg), []string{"a", "b", "c", "go"})
+
+	mustRun(t)(mg.Rollback())
+	assertEqual(t, "tables after rollback", tables(t, mg), []string{"a", "b", "c"})
+}
+
+// recordingHooks records the hooks called
+type recordingHooks struct {
+	calls []string
+	// failOn makes the hook of that name fail
+	failOn string
+}
+
+func (h *recordingHooks) record(name string) error {
+	h.calls = append(h.calls, name)
+	if name == h.failOn {
+		return errors.New("hook failed")
+	}
+	return nil
+}
+
+func (h *recordingHooks) BeforeMigrate(ctx context.Context, plan *Plan) error {
+	return h.record("beforeMigrate")
+}
+
+func (h *recordingHooks) AfterMigrate(ctx context.Context, executed []MigrationResult) error {
+	return h.record("afterMigrate")
+}
+
+func (h *recordingHooks) BeforeRollback(ctx context.Context, plan *Plan) error {
+	return h.record("beforeRollback")
+}
+
+func (h *recordingHooks) AfterRollback(ctx context.Context, executed []MigrationResult) error {
+	return h.record("afterRollback")
+}
+
+func (h *recordingHooks) BeforeEach(ctx context.Context, step PlanStep) error {
+	return h.record("beforeEach " + step.Version)
+}
+
+func (h *recordingHooks) AfterEach(ctx context.Context, result MigrationResult) error {
+	return h.record("afterEach " + result.Version)
+}
+
+func (h *recordingHooks) OnError(ctx context.Context, step PlanStep, err error) {
+	h.record("onError " + step.Version)
+}
+
+func TestMigratorHooks(t *testing.T) {
+	failing := testFiles()
+	failing["002_b.sql"] = &fstest.MapFile{Data: []byte("-- migrate:up\ninsert into missing values (1);\n")}
+
+	tests := []struct {
+		name   string
+		files  fstest.MapFS
+		failOn string
+		run    func(mg *Migrator) (*RunResult, error)
+		want   []string
+	}{
+		{
+			name:  "migrate",
+			files: testFiles(),
+			run:   func(mg *Migrator) (*RunResult, error) { return mg.Up(2) },
+			want:  []string{"beforeMigrate", "beforeEach 001", "afterEach 001", "beforeEach 002", "afterEach 002", "afterMigrate"},
+		},
+		{
+			name:  "rollback",
+			files: testFiles(),
+			run: func(mg *Migrator) (*RunResult, error) {
+				if _, err := mg.Migrate(); err != nil {
+					return nil, err
+				}
+				mg.Hooks.(*recordingHooks).calls = nil
+				return mg.Rollback()
+			},
+			want: []string{"beforeRollback", "beforeEach 003", "afterEach 003", "afterRollback"},
+		},
+		{
+			name:  "failing migration",
+			files: failing,
+			run:   (*Migrator).Migrate,
+			want:  []string{"beforeMigrate", "beforeEach 001", "afterEach 001", "beforeEach 002", "onError 002"},
+		},
+		{
+			name:   "failing hook cancels the migration",
+			files:  testFiles(),
+			failOn: "beforeEach 002",
+			run:    (*Migrator).Migrate,
+			want:   []string{"beforeMigrate", "beforeEach 001", "afterEach 001", "beforeEach 002"},
+		},
+		{
+			name:  "baseline calls no hook",
+			files: testFiles(),
+			run:   func(mg *Migrator) (*RunResult, error) { return mg.Baseline("003") },
+			want:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hooks := &recordingHooks{failOn: test.failOn}
+			mg := newTestMigrator(t, test.files)
+			mg.Hooks = hooks
+			test.run(mg)
+			assertEqual(t, "calls", hooks.calls, test.want)
+		})
+	}
+}
+
+func TestMigratorSQLCallbacks(t *testing.T) {
+	files := testFiles()
+	files["beforeMigrate.sql"] = &fstest.MapFile{Data: []byte("create table if not exists log (event text);\n")}
+	files["afterEachMigrate.sql"] = &fstest.MapFile{Data: []byte("insert into log values ('${event}');\n")}
+	files["afterMigrate.sql"] = &fstest.MapFile{Data: []byte("insert into log values ('done');\n")}
+	mg := newTestMigrator(t, files)
+	mg.Placeholders = map[string]string{"event": "migrated"}
+
+	mustRun(t)(mg.Up(2))
+
+	sqlDB, err := mg.openDatabase(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	rows, err := sqlDB.Query("select event from log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	events := []string{}
+	for rows.Next() {
+		var event string
+		if err := rows.Scan(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	assertEqual(t, "events", events, []string{"migrated", "migrated", "done"})
+}
+
+func TestMigratorChecksum(t *testing.T) {
+	files := testFiles()
+	mg := newTestMigrator(t, files)
+	mustRun(t)(mg.Up(2))
+
+	files["001_a.sql"] = &fstest.MapFile{Data: []byte("-- migrate:up\ncreate table a (id int, name text);\n\n-- migrate:down\ndrop table a;\n")}
+	if err := mg.Validate(); !errors.Is(err, merrors.ErrChecksumMismatch) {
+		t.Errorf("Validate() error = %v, want a checksum mismatch", err)
+	}
+	if _, err := mg.Migrate(); !errors.Is(err, merrors.ErrChecksumMismatch) {
+		t.Errorf("Migrate() error = %v, want a checksum mismatch", err)
+	}
+
+	// rolling back is allowed to recover from an edited migration
+	result, err := mg.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "rolled back", versions(result.Migrations), []string{"down 002"})
+
+	mg.AllowModified = true
+	result, err = mg.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(strings.Join(versions(result.Migrations), ","), "up 002") {
+		t.Errorf("executed = %v, want 002 and 003", versions(result.Migrations))
+	}
+}
+
+func TestMigratorPlaceholder(t *testing.T) {
+	const env = constants.PlaceholderEnvPrefix + "MIGRATOR_TEST_SCHEMA"
+	previous, set := os.LookupEnv(env)
+	defer func() {
+		if set {
+			os.Setenv(env, previous)
+		} else {
+			os.Unsetenv(env)
+		}
+	}()
+
+	tests := []struct {
+		name         string
+		placeholders map[string]string
+		env          string
+		want         string
+		ok           bool
+	}{
+		{name: "undefined", ok: false},
+		{name: "environment fallback", env: "from_env", want: "from_env", ok: true},
+		{name: "configured", placeholders: map[string]string{"migrator_test_schema": "configured"}, want: "configured", ok: true},
+		{name: "configured before environment", placeholders: map[string]string{"Migrator_Test_Schema": "configured"}, env: "from_env", want: "configured", ok: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Unsetenv(env)
+			if test.env != "" {
+				os.Setenv(env, test.env)
+			}
+			mg := &Migrator{Placeholders: test.placeholders}
+			got, ok := mg.placeholder("migrator_test_schema")
+			if got != test.want || ok != test.ok {
+				t.Errorf("placeholder() = %q, %v, want %q, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestMigratorBaselineVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		valid   bool
+	}{
+		{version: "002", valid: true},
+		{version: "20240101120000", valid: true},
+		{version: ""},
+		{version: "abc"},
+		{version: "002_b"},
+		{version: "002.sql"},
+		{version: "-1"},
+		{version: " 002"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			_, err := newTestMigrator(t, testFiles()).PlanBaseline(test.version)
+			if (err == nil) != test.valid {
+				t.Errorf("PlanBaseline(%q) error = %v, want valid %v", test.version, err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/constants"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+	"github.com/hchenc/migrator/pkg/utils"
+)
+
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+	// DirectionBaseline records a migration as applied without running it
+	DirectionBaseline = "baseline"
+)
+
+// PlanStep is a migration the migrator would execute
+type PlanStep struct {
+	Direction   string `json:"direction"`
+	Version     string `json:"version"`
+	Filename    string `json:"filename"`
+	Transaction bool   `json:"transaction"`
+	SQL         string `json:"sql"`
+
+	migration  Migration
+	statements []utils.Statement
+	// record is the row of a migration being rolled back or of a repeatable migration being re-applied
+	record backends.MigrationRecord
+}
+
+// Plan lists the migrations the migrator would execute, in order
+type Plan struct {
+	// Target is the version a goto lands on, empty otherwise
+	Target string     `json:"target,omitempty"`
+	Steps  []PlanStep `json:"steps"`
+}
+
+// planner builds a plan from the migration files and the applied migrations
+type planner func(files []string, applied map[string]backends.MigrationRecord) (*Plan, error)
+
+// applies reports whether the plan applies at least one migration
+func (plan *Plan) applies() bool {
+	for _, step := range plan.Steps {
+		if step.Direction == DirectionUp {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the plan in a human readable form, including the sql of every step if verbose
+func (plan *Plan) Print(w io.Writer, verbose bool) {
+	if len(plan.Steps) == 0 {
+		if plan.Target != "" {
+			fmt.Fprintf(w, "Already at version %s\n", plan.Target)
+		} else {
+			fmt.Fprintln(w, "Nothing to do")
+		}
+		return
+	}
+
+	if plan.Target != "" {
+		fmt.Fprintf(w, "Plan to reach version %s:\n", plan.Target)
+	} else {
+		fmt.Fprintln(w, "Plan:")
+	}
+
+	for _, step := range plan.Steps {
+		transaction := "transaction"
+		if !step.Transaction {
+			transaction = "no transaction"
+		}
+		fmt.Fprintf(w, "  %-4s %s (%s)\n", step.Direction, step.Filename, transaction)
+		if verbose && step.SQL != "" {
+			for _, line := range strings.Split(strings.TrimSpace(step.SQL), "\n") {
+				fmt.Fprintf(w, "       %s\n", line)
+			}
+		}
+	}
+}
+
+// Plan returns the migrations Migrate would execute, without touching the database
+func (migrator *Migrator) Plan() (*Plan, error) {
+	return migrator.PlanContext(context.Background())
+}
+
+// PlanContext is Plan with a context
+func (migrator *Migrator) PlanContext(ctx context.Context) (*Plan, error) {
+	return migrator.plan(ctx, migrator.planUp(0))
+}
+
+// PlanUp returns the migrations Up would execute, without touching the database
+func (migrator *Migrator) PlanUp(step uint) (*Plan, error) {
+	return migrator.PlanUpContext(context.Background(), step)
+}
+
+// PlanUpContext is PlanUp with a context
+func (migrator *Migrator) PlanUpContext(ctx context.Context, step uint) (*Plan, error) {
+	return migrator.plan(ctx, migrator.planUp(int(step)))
+}
+
+// PlanDown returns the migrations Down would roll back, without touching the database
+func (migrator *Migrator) PlanDown(step uint) (*Plan, error) {
+	return migrator.PlanDownContext(context.Background(), step)
+}
+
+// PlanDownContext is PlanDown with a context
+func (migrator *Migrator) PlanDownContext(ctx context.Context, step uint) (*Plan, error) {
+	return migrator.plan(ctx, migrator.planDown(int(step)))
+}
+
+// PlanRedo returns the migrations Redo would roll back and apply again, without touching the database
+func (migrator *Migrator) PlanRedo(step uint) (*Plan, error) {
+	return migrator.PlanRedoContext(context.Background(), step)
+}
+
+// PlanRedoContext is PlanRedo with a context
+func (migrator *Migrator) PlanRedoContext(ctx context.Context, step uint) (*Plan, error) {
+	return migrator.plan(ctx, migrator.planRedo(int(step)))
+}
+
+// PlanGoto returns the migrations Goto would execute, without touching the database
+func (migrator *Migrator) PlanGoto(version string) (*Plan, error) {
+	return migrator.PlanGotoContext(context.Background(), version)
+}
+
+// PlanGotoContext is PlanGoto with a context
+func (migrator *Migrator) PlanGotoContext(ctx context.Context, version string) (*Plan, error) {
+	return migrator.plan(ctx, migrator.planGoto(version))
+}
+
+// PlanBaseline returns the migrations Baseline would record, without touching the database
+func (migrator *Migrator) PlanBaseline(version string) (*Plan, error) {
+	return migrator.PlanBaselineContext(context.Background(), version)
+}
+
+// PlanBaselineContext is PlanBaseline with a context
+func (migrator *Migrator) PlanBaselineContext(ctx context.Context, version string) (*Plan, error) {
+	return migrator.plan(ctx, migrator.planBaseline(version))
+}
+
+// plan builds the plan of planner without touching the database
+func (migrator *Migrator) plan(ctx context.Context, planner planner) (*Plan, error) {
+	plan, _, err := migrator.execute(ctx, planner, false)
+	return plan, err
+}
+
+// planUp applies pending migrations in order, at most step of them when positive
+func (migrator *Migrator) planUp(step int) planner {
+	return func(files []string, applied map[string]backends.MigrationRecord) (*Plan, error) {
+		// step counts pending migrations, wherever they are in the list of files
+		pending := pendingMigrations(files, applied)
+		// repeatable migrations run once every versioned migration is applied
+		repeatable := step <= 0 || step >= len(pending)
+		if step > 0 && step < len(pending) {
+			pending = pending[0:step]
+		}
+
+		plan := &Plan{}
+		for _, filename := range pending {
+			s, err := migrator.upStep(filename)
+			if err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, s)
+		}
+
+		if repeatable {
+			steps, err := migrator.repeatableSteps(applied)
+			if err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, steps...)
+		}
+		return plan, nil
+	}
+}
+
+// planDown rolls back the step most recent migrations
+func (migrator *Migrator) planDown(step int) planner {
+	return func(files []string, applied map[string]backends.MigrationRecord) (*Plan, error) {
+		records := sortedRecords(applied)
+		if len(records) == 0 {
+			return nil, fmt.Errorf("can't rollback: %w", merrors.ErrNoAppliedMigrations)
+		}
+
+		if step < len(records) {
+			records = records[0:step]
+		}
+
+		plan := &Plan{}
+		for _, record := range records {
+			s, err := migrator.downStep(record)
+			if err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, s)
+		}
+		return plan, nil
+	}
+}
+
+// planRedo rolls back the step most recent migrations then applies them again, oldest first
+func (migrator *Migrator) planRedo(step int) planner {
+	return func(files []string, applied map[string]backends.MigrationRecord) (*Plan, error) {
+		plan, err := migrator.planDown(step)(files, applied)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(plan.Steps) - 1; i >= 0; i-- {
+			s, err := migrator.upStep(plan.Steps[i].Filename)
+			if err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, s)
+		}
+		return plan, nil
+	}
+}
+
+// planGoto rolls back migrations newer than version, then applies pending ones up to and including it,
+// version 0 rolls back every migration
+func (migrator *Migrator) planGoto(version string) planner {
+	return func(files []string, applied map[string]backends.MigrationRecord) (*Plan, error) {
+		known := utils.CompareVersions(version, "0") == 0
+		for _, filename := range files {
+			if utils.MigrationVersion(filename) == version {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%w for version %s", merrors.ErrNoMigrations, version)
+		}
+
+		plan := &Plan{Target: version}
+
+		// roll back newer versions first, most recent first
+		for _, record := range sortedRecords(applied) {
+			if utils.CompareVersions(record.Version, version) <= 0 {
+				continue
+			}
+			s, err := migrator.downStep(record)
+			if err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, s)
+		}
+
+		for _, filename := range pendingMigrations(files, applied) {
+			if utils.CompareVersions(utils.MigrationVersion(filename), version) > 0 {
+				continue
+			}
+			s, err := migrator.upStep(filename)
+			if err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, s)
+		}
+
+		return plan, nil
+	}
+}
+
+// baselineVersionRegExp matches the versions migrations can be baselined at
+var baselineVersionRegExp = regexp.MustCompile(`^\d+$`)
+
+// planBaseline records every pending migration up to and including version as applied
+func (migrator *Migrator) planBaseline(version string) planner {
+	return func(files []string, applied map[string]backends.MigrationRecord) (*Plan, error) {
+		if !baselineVersionRegExp.MatchString(version) {
+			return nil, fmt.Errorf("invalid baseline version `%s`, it must be numeric", version)
+		}
+
+		plan := &Plan{Target: version}
+		for _, filename := range pendingMigrations(files, applied) {
+			if utils.CompareVersions(utils.MigrationVersion(filename), version) > 0 {
+				continue
+			}
+			s, err := migrator.upStep(filename)
+			if err != nil {
+				return nil, err
+			}
+			s.Direction = DirectionBaseline
+			plan.Steps = append(plan.Steps, s)
+		}
+		return plan, nil
+	}
+}
+
+// upStep parses the up block of a migration file
+func (migrator *Migrator) upStep(filename string) (PlanStep, error) {
+	up, _, err := migrator.parseMigration(filename)
+	if err != nil {
+		return PlanStep{}, err
+	}
+
+	sql, statements, err := migrator.splitStatements(filename, up)
+	if err != nil {
+		return PlanStep{}, err
+	}
+
+	return PlanStep{
+		Direction:   DirectionUp,
+		Version:     utils.MigrationVersion(filename),
+		Filename:    filename,
+		Transaction: up.Options.Transaction(),
+		SQL:         sql,
+		migration:   up,
+		statements:  statements,
+	}, nil
+}
+
+// downStep parses the down block of the migration file of an applied migration
+func (migrator *Migrator) downStep(record backends.MigrationRecord) (PlanStep, error) {
+	filename, err := migrator.findMigration(record.Version)
+	if err != nil {
+		return PlanStep{}, err
+	}
+
+	_, down, err := migrator.parseMigration(filename)
+	if err != nil {
+		return PlanStep{}, err
+	}
+
+	sql, statements, err := migrator.splitStatements(filename, down)
+	if err != nil {
+		return PlanStep{}, err
+	}
+
+	return PlanStep{
+		Direction:   DirectionDown,
+		Version:     record.Version,
+		Filename:    filename,
+		Transaction: down.Options.Transaction(),
+		SQL:         sql,
+		migration:   down,
+		statements:  statements,
+		record:      record,
+	}, nil
+}
+
+// repeatableSteps applies the repeatable migrations which are new or changed since they were last applied
+func (migrator *Migrator) repeatableSteps(applied map[string]backends.MigrationRecord) ([]PlanStep, error) {
+	files, err := migrator.findRepeatableMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []PlanStep
+	for _, filename := range files {
+		s, err := migrator.upStep(filename)
+		if err != nil {
+			return nil, err
+		}
+		if record, ok := applied[s.Version]; ok {
+			if record.Checksum == migrationChecksum(s.migration) {
+				continue
+			}
+			s.record = record
+		}
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+// splitStatements replaces the placeholders of a migration block unless disabled, then splits it
+// into statements numbering their lines from the start of the file. It returns the replaced sql too.
+func (migrator *Migrator) splitStatements(filename string, migration Migration) (string, []utils.Statement, error) {
+	sql := migration.Contents
+	if migration.Options.Placeholders() {
+		var err error
+		if sql, err = utils.ReplacePlaceholders(sql, migrator.placeholder); err != nil {
+			return "", nil, fmt.Errorf("%w %s: %s", merrors.ErrInvalidMigration, filename, err)
+		}
+	}
+
+	statements, err := utils.SplitStatements(sql, migrator.backend.Dialect())
+	if err != nil {
+		return "", nil, fmt.Errorf("%w %s: %s", merrors.ErrInvalidMigration, filename, err)
+	}
+	for i := range statements {
+		statements[i].Line += migration.Line - 1
+	}
+	return sql, statements, nil
+}
+
+// placeholder returns the value of a placeholder from Placeholders, or else from the
+// MIGRATOR_VAR_<NAME> environment variable. Names are case insensitive.
+func (migrator *Migrator) placeholder(name string) (string, bool) {
+	for key, value := range migrator.Placeholders {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return os.LookupEnv(constants.PlaceholderEnvPrefix + strings.ToUpper(name))
+}
+
+// result describes the step before it is executed
+func (step PlanStep) result() MigrationResult {
+	return MigrationResult{
+		Version:     step.Version,
+		Filename:    step.Filename,
+		Description: utils.MigrationDescription(step.Filename),
+		Direction:   step.Direction,
+	}
+}
+
+// sortedRecords returns the applied versioned migrations, most recent first,
+// repeatable migrations are never rolled back
+func sortedRecords(applied map[string]backends.MigrationRecord) []backends.MigrationRecord {
+	records := make([]backends.MigrationRecord, 0, len(applied))
+	for _, record := range applied {
+		if utils.IsRepeatable(record.Version) {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return utils.CompareVersions(records[i].Version, records[j].Version) > 0
+	})
+	return records
+}
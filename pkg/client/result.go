@@ -0,0 +1,151 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/utils"
+)
+
+// MigrationResult describes a migration, either its state in the migrations table
+// or what happened when a command executed it
+type MigrationResult struct {
+	Version     string `json:"version" yaml:"version"`
+	Filename    string `json:"filename,omitempty" yaml:"filename,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Direction is set for migrations executed by a command
+	Direction string     `json:"direction,omitempty" yaml:"direction,omitempty"`
+	Dirty     bool       `json:"dirty" yaml:"dirty"`
+	Checksum  string     `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	AppliedAt *time.Time `json:"applied_at,omitempty" yaml:"applied_at,omitempty"`
+	AppliedBy string     `json:"applied_by,omitempty" yaml:"applied_by,omitempty"`
+	Hostname  string     `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	// Duration is how long the migration took to execute
+	Duration Duration `json:"duration_ms" yaml:"duration_ms"`
+	// OutOfOrder is set for pending migrations older than the latest applied one
+	OutOfOrder bool `json:"out_of_order,omitempty" yaml:"out_of_order,omitempty"`
+	// Error is set when executing the migration failed
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Duration is a time.Duration marshalled as milliseconds
+type Duration time.Duration
+
+func (d Duration) milliseconds() float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.milliseconds())
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.milliseconds(), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// StatusReport lists the applied and pending migrations, in order
+type StatusReport struct {
+	Applied []MigrationResult `json:"applied" yaml:"applied"`
+	Pending []MigrationResult `json:"pending" yaml:"pending"`
+}
+
+// RunResult lists the migrations executed by a command, in order
+type RunResult struct {
+	// Target is the version a goto lands on, empty otherwise
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+	// DryRun means Migrations were only planned, not executed
+	DryRun     bool              `json:"dry_run" yaml:"dry_run"`
+	Migrations []MigrationResult `json:"migrations" yaml:"migrations"`
+}
+
+// newMigrationResult describes an applied migration from its migrations table row
+func newMigrationResult(filename string, record backends.MigrationRecord) MigrationResult {
+	res := MigrationResult{
+		Version:     record.Version,
+		Filename:    filename,
+		Description: record.Description,
+		Dirty:       record.Dirty,
+		Checksum:    record.Checksum,
+		AppliedBy:   record.AppliedBy,
+		Hostname:    record.Hostname,
+		Duration:    Duration(record.ExecutionTime),
+	}
+	if !record.AppliedAt.IsZero() {
+		appliedAt := record.AppliedAt
+		res.AppliedAt = &appliedAt
+	}
+	return res
+}
+
+// newPendingResult describes a migration file which has not been applied yet
+func newPendingResult(filename string) MigrationResult {
+	return MigrationResult{
+		Version:     utils.MigrationVersion(filename),
+		Filename:    filename,
+		Description: utils.MigrationDescription(filename),
+	}
+}
+
+// Dirty returns the number of applied migrations left dirty
+func (report *StatusReport) Dirty() int {
+	var dirty int
+	for _, res := range report.Applied {
+		if res.Dirty {
+			dirty++
+		}
+	}
+	return dirty
+}
+
+// OutOfOrder returns the number of pending migrations older than the latest applied one
+func (report *StatusReport) OutOfOrder() int {
+	var outOfOrder int
+	for _, res := range report.Pending {
+		if res.OutOfOrder {
+			outOfOrder++
+		}
+	}
+	return outOfOrder
+}
+
+// Print writes the report in a human readable form
+func (report *StatusReport) Print(w io.Writer) {
+	for _, res := range report.Applied {
+		if res.Dirty {
+			fmt.Fprintf(w, "[!] %s (dirty)\n", res.Filename)
+		} else if res.AppliedAt != nil {
+			fmt.Fprintf(w, "[V] %s (applied at %s by %s@%s in %s)\n", res.Filename,
+				res.AppliedAt.Local().Format("2006-01-02 15:04:05"), res.AppliedBy,
+				res.Hostname, res.Duration)
+		} else {
+			fmt.Fprintf(w, "[V] %s\n", res.Filename)
+		}
+	}
+	for _, res := range report.Pending {
+		if res.AppliedAt != nil {
+			fmt.Fprintf(w, "[X] %s (changed since applied at %s)\n", res.Filename,
+				res.AppliedAt.Local().Format("2006-01-02 15:04:05"))
+		} else if res.OutOfOrder {
+			fmt.Fprintf(w, "[X] %s (out of order)\n", res.Filename)
+		} else {
+			fmt.Fprintf(w, "[X] %s\n", res.Filename)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Applied: %d\n", len(report.Applied))
+	fmt.Fprintf(w, "Pending: %d\n", len(report.Pending))
+	if dirty := report.Dirty(); dirty > 0 {
+		fmt.Fprintf(w, "Dirty: %d\n", dirty)
+	}
+	if outOfOrder := report.OutOfOrder(); outOfOrder > 0 {
+		fmt.Fprintf(w, "Out of order: %d\n", outOfOrder)
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+)
+
+// RetryPolicy decides how transactional migrations failing with a transient error,
+// such as a deadlock or a lost connection, are run again. The failed transaction is rolled back
+// first so that nothing was changed. Migrations running outside of a transaction are never retried,
+// neither are failed commits, which may have been applied, nor, on databases committing DDL
+// implicitly such as MySQL, failures after the first statement of the migration.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, no retry below 2
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled before each following one
+	Backoff time.Duration
+}
+
+// delay returns how long to wait after the failed attempt, counted from 1
+func (policy RetryPolicy) delay(attempt int) time.Duration {
+	return policy.Backoff * time.Duration(1<<uint(attempt-1))
+}
+
+// doTransaction runs txFunc in a transaction, running it again in a new transaction
+// as allowed by the retry policy when it fails with a transient error
+func (migrator *Migrator) doTransaction(ctx context.Context, sqlDB *sql.DB, txFunc func(backends.Transaction) error) error {
+	for attempt := 1; ; attempt++ {
+		err := doTransaction(ctx, sqlDB, txFunc)
+		if err == nil || attempt >= migrator.Retry.MaxAttempts || !migrator.retryable(err) {
+			return err
+		}
+
+		delay := migrator.Retry.delay(attempt)
+		fmt.Fprintf(migrator.Log, "Retrying in %s, attempt %d of %d failed: %s\n",
+			delay, attempt, migrator.Retry.MaxAttempts, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryable reports whether a failed transaction may run again without applying twice what
+// was already committed
+func (migrator *Migrator) retryable(err error) bool {
+	var commitErr *commitError
+	if !migrator.backend.IsRetryable(err) || errors.As(err, &commitErr) {
+		return false
+	}
+	if migrator.backend.TransactionalDDL() {
+		return true
+	}
+
+	// a statement before the failing one may have committed the transaction
+	var migrationErr *merrors.MigrationError
+	return errors.As(err, &migrationErr) && migrationErr.Index == 1
+}
+
+// commitError is returned when committing a transaction failed, its outcome is unknown
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
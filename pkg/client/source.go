@@ -0,0 +1,63 @@
+package client
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/hchenc/migrator/pkg/utils"
+)
+
+// MigrationSource provides the migration files, see NewDirSource and NewFSSource
+type MigrationSource interface {
+	// FindMigrationFiles returns the names of the migration files matching re, in order
+	FindMigrationFiles(re *regexp.Regexp) ([]string, error)
+	// FindMigrationFile returns the name of the migration file holding version
+	FindMigrationFile(version string) (string, error)
+	// ReadMigrationFile returns the contents of a migration file
+	ReadMigrationFile(filename string) ([]byte, error)
+}
+
+type dirSource struct {
+	dir string
+}
+
+// NewDirSource reads the migration files from a directory, the default source using MigrationsLocation
+func NewDirSource(dir string) MigrationSource {
+	return &dirSource{dir: dir}
+}
+
+func (s *dirSource) FindMigrationFiles(re *regexp.Regexp) ([]string, error) {
+	return utils.FindMigrationFiles(s.dir, re)
+}
+
+func (s *dirSource) FindMigrationFile(version string) (string, error) {
+	return utils.FindMigrationFile(s.dir, version)
+}
+
+func (s *dirSource) ReadMigrationFile(filename string) ([]byte, error) {
+	return fs.ReadFile(os.DirFS(s.dir), filename)
+}
+
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+// NewFSSource reads the migration files from dir in fsys, e.g. an embed.FS compiled in the binary
+func NewFSSource(fsys fs.FS, dir string) MigrationSource {
+	return &fsSource{fsys: fsys, dir: dir}
+}
+
+func (s *fsSource) FindMigrationFiles(re *regexp.Regexp) ([]string, error) {
+	return utils.FindMigrationFilesFS(s.fsys, s.dir, re)
+}
+
+func (s *fsSource) FindMigrationFile(version string) (string, error) {
+	return utils.FindMigrationFileFS(s.fsys, s.dir, version)
+}
+
+func (s *fsSource) ReadMigrationFile(filename string) ([]byte, error) {
+	return fs.ReadFile(s.fsys, path.Join(s.dir, filename))
+}
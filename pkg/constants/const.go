@@ -1,7 +1,42 @@
 package constants
 
+import "time"
+
 const DefaultMigrationsLocation = "./db/migrations"
 
 const DefaultMigrationsTable = "schema_history"
 
+const DefaultSchemaFile = "./db/schema.sql"
+
+const DefaultLockTimeout = 5 * time.Minute
+
+// ShutdownTimeout is how long the rest api waits for interrupted operations to roll back
+const ShutdownTimeout = 30 * time.Second
+
+// PlaceholderEnvPrefix prefixes the environment variables holding placeholder values,
+// e.g. MIGRATOR_VAR_TENANT_SCHEMA for ${tenant_schema}
+const PlaceholderEnvPrefix = "MIGRATOR_VAR_"
+
+// Retry policy of transactional migrations failing with a transient error
+const (
+	DefaultRetryAttempts = 3
+	DefaultRetryBackoff  = time.Second
+)
+
+// Policies for pending migrations older than the latest applied one
+const (
+	OutOfOrderAllow = "allow"
+	OutOfOrderWarn  = "warn"
+	OutOfOrderFail  = "fail"
+)
+
+const DefaultOutOfOrder = OutOfOrderWarn
+
 const MigrationTemplate = "-- migrate:up\n\n\n-- migrate:down\n\n"
+
+// Output formats of the command line
+const (
+	OutputText = "text"
+	OutputJson = "json"
+	OutputYaml = "yaml"
+)
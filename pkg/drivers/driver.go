@@ -1,23 +1,66 @@
 package drivers
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DriverType string
 
+// MigrationsColumns lists the columns of the migrations table in select and insert order
+const MigrationsColumns = "version, description, checksum, applied_at, applied_by, hostname, execution_time, dirty"
+
+// Column is a migrations table column added in place to tables created by older versions
+type Column struct {
+	Name       string
+	Definition string
+}
+
 type DriverService interface {
 	Open() (*sql.DB, error)
-	SchemaExists() (bool, error)
-	CreateSchema() error
-	DropSchema() error
-	DumpSchema(db *sql.DB) ([]byte, error)
-	CreateMigrationsTable(db *sql.DB) error
-	SelectMigrations(db *sql.DB, id int) (map[string]bool, error)
-	InsertMigration(tx backends.Transaction, version string) error
-	DeleteMigration(tx backends.Transaction, version string) error
-	Ping() error
+	SchemaExists(ctx context.Context) (bool, error)
+	CreateSchema(ctx context.Context) error
+	DropSchema(ctx context.Context) error
+	DumpSchema(ctx context.Context, db *sql.DB) ([]byte, error)
+	CreateMigrationsTable(ctx context.Context, db *sql.DB) error
+	// MigrationsTableColumns lists the columns of the migrations table, none if it doesn't exist
+	MigrationsTableColumns(ctx context.Context, db *sql.DB) ([]string, error)
+	SelectMigrations(ctx context.Context, db *sql.DB, id int) (map[string]backends.MigrationRecord, error)
+	// SelectMigrationVersions reads the applied versions of a migrations table created by an older
+	// version, which may lack the other columns until CreateMigrationsTable upgrades it
+	SelectMigrationVersions(ctx context.Context, db *sql.DB) (map[string]backends.MigrationRecord, error)
+	InsertMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error
+	UpdateMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error
+	DeleteMigration(ctx context.Context, tx backends.Transaction, version string) error
+	// Lock acquires a lock shared by every migrator of the database, waiting at most timeout
+	// (forever if not positive), and returns the function releasing it
+	Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (func() error, error)
+	// SetLockWaitTimeout limits how long the statements run on tx wait for table and row locks,
+	// and returns the function restoring the previous limit
+	SetLockWaitTimeout(ctx context.Context, tx backends.Transaction, timeout time.Duration) (func() error, error)
+	// KillOnCancel makes the statements run on tx stop on the server once ctx is done, when
+	// cancelling a query only closes the client side of the connection. The returned function
+	// stops watching ctx and must be called before tx runs anything else.
+	KillOnCancel(ctx context.Context, db *sql.DB, tx backends.Transaction) (func(), error)
+	// TransactionalDDL reports whether schema changes are rolled back with the transaction,
+	// otherwise they commit it implicitly
+	TransactionalDDL() bool
+	// IsRetryable reports whether err is transient, such as a deadlock or a lost connection,
+	// so that a rolled back transaction may succeed when run again
+	IsRetryable(err error) bool
+	Ping(ctx context.Context) error
+	// Dialect is the sql syntax used to split migrations into statements
+	Dialect() utils.Dialect
 }
 
 type backend struct {
@@ -28,40 +71,76 @@ func (b *backend) OpenDatabase() (*sql.DB, error) {
 	return b.ds.Open()
 }
 
-func (b *backend) DatabaseExists() (bool, error) {
-	return b.ds.SchemaExists()
+func (b *backend) DatabaseExists(ctx context.Context) (bool, error) {
+	return b.ds.SchemaExists(ctx)
+}
+
+func (b *backend) CreateDatabase(ctx context.Context) error {
+	return b.ds.CreateSchema(ctx)
+}
+
+func (b *backend) DropDatabase(ctx context.Context) error {
+	return b.ds.DropSchema(ctx)
+}
+
+func (b *backend) DumpSchema(ctx context.Context, db *sql.DB) ([]byte, error) {
+	return b.ds.DumpSchema(ctx, db)
+}
+
+func (b *backend) CreateMigrationsTable(ctx context.Context, db *sql.DB) error {
+	return b.ds.CreateMigrationsTable(ctx, db)
+}
+
+func (b *backend) MigrationsTableColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	return b.ds.MigrationsTableColumns(ctx, db)
+}
+
+func (b *backend) SelectMigrationVersions(ctx context.Context, db *sql.DB) (map[string]backends.MigrationRecord, error) {
+	return b.ds.SelectMigrationVersions(ctx, db)
+}
+
+func (b *backend) SelectMigrations(ctx context.Context, db *sql.DB, id int) (map[string]backends.MigrationRecord, error) {
+	return b.ds.SelectMigrations(ctx, db, id)
+}
+
+func (b *backend) InsertMigration(ctx context.Context, transation backends.Transaction, migration backends.MigrationRecord) error {
+	return b.ds.InsertMigration(ctx, transation, migration)
+}
+
+func (b *backend) UpdateMigration(ctx context.Context, transation backends.Transaction, migration backends.MigrationRecord) error {
+	return b.ds.UpdateMigration(ctx, transation, migration)
 }
 
-func (b *backend) CreateDatabase() error {
-	panic("implement me")
+func (b *backend) DeleteMigration(ctx context.Context, transation backends.Transaction, migration string) error {
+	return b.ds.DeleteMigration(ctx, transation, migration)
 }
 
-func (b *backend) DropDatabase() error {
-	return b.ds.CreateSchema()
+func (b *backend) Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (func() error, error) {
+	return b.ds.Lock(ctx, db, timeout)
 }
 
-func (b *backend) DumpSchema(db *sql.DB) ([]byte, error) {
-	return b.ds.DumpSchema(db)
+func (b *backend) SetLockWaitTimeout(ctx context.Context, tx backends.Transaction, timeout time.Duration) (func() error, error) {
+	return b.ds.SetLockWaitTimeout(ctx, tx, timeout)
 }
 
-func (b *backend) CreateMigrationsTable(db *sql.DB) error {
-	return b.ds.CreateMigrationsTable(db)
+func (b *backend) KillOnCancel(ctx context.Context, db *sql.DB, tx backends.Transaction) (func(), error) {
+	return b.ds.KillOnCancel(ctx, db, tx)
 }
 
-func (b *backend) SelectMigrations(db *sql.DB, id int) (map[string]bool, error) {
-	return b.ds.SelectMigrations(db, id)
+func (b *backend) TransactionalDDL() bool {
+	return b.ds.TransactionalDDL()
 }
 
-func (b *backend) InsertMigration(transation backends.Transaction, migration string) error {
-	return b.ds.InsertMigration(transation, migration)
+func (b *backend) IsRetryable(err error) bool {
+	return b.ds.IsRetryable(err)
 }
 
-func (b *backend) DeleteMigration(transation backends.Transaction, migration string) error {
-	return b.ds.DeleteMigration(transation, migration)
+func (b *backend) Ping(ctx context.Context) error {
+	return b.ds.Ping(ctx)
 }
 
-func (b *backend) Ping() error {
-	return b.ds.Ping()
+func (b *backend) Dialect() utils.Dialect {
+	return b.ds.Dialect()
 }
 
 func NewBackendService(ds DriverService) backends.Interface {
@@ -75,3 +154,160 @@ var DriverMap = map[DriverType]DriverGenerator{}
 func RegisterDriver(generator DriverGenerator, driverType DriverType) {
 	DriverMap[driverType] = generator
 }
+
+// IsBadConn reports whether err means the connection was lost
+func IsBadConn(err error) bool {
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// MissingColumns returns the columns which are not part of existing
+func MissingColumns(existing []string, columns []Column) []Column {
+	found := map[string]bool{}
+	for _, name := range existing {
+		found[name] = true
+	}
+
+	var missing []Column
+	for _, column := range columns {
+		if !found[column.Name] {
+			missing = append(missing, column)
+		}
+	}
+	return missing
+}
+
+// ScanMigrations reads the rows of a migrations table query selecting MigrationsColumns
+func ScanMigrations(rows *sql.Rows) (map[string]backends.MigrationRecord, error) {
+	migrations := map[string]backends.MigrationRecord{}
+	for rows.Next() {
+		var record backends.MigrationRecord
+		var appliedAt sql.NullTime
+		var executionTime int64
+		if err := rows.Scan(&record.Version, &record.Description, &record.Checksum, &appliedAt,
+			&record.AppliedBy, &record.Hostname, &executionTime, &record.Dirty); err != nil {
+			return nil, err
+		}
+		record.AppliedAt = appliedAt.Time
+		record.ExecutionTime = time.Duration(executionTime) * time.Millisecond
+		migrations[record.Version] = record
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// QueryColumn returns the values of the single column selected by query
+func QueryColumn(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// AddMissingColumns upgrades a migrations table created by an older version in place
+func AddMissingColumns(ctx context.Context, db *sql.DB, table string, existing []string, columns []Column) error {
+	for _, column := range MissingColumns(existing, columns) {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("alter table %s add column %s %s",
+			table, column.Name, column.Definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsLegacyMigrationsTable reports whether a migrations table with the existing columns was
+// created by an older version and lacks some of MigrationsColumns
+func IsLegacyMigrationsTable(existing []string) bool {
+	found := map[string]bool{}
+	for _, name := range existing {
+		found[name] = true
+	}
+	for _, name := range strings.Split(MigrationsColumns, ", ") {
+		if !found[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectMigrationVersions reads the versions of a legacy migrations table, which has no other column
+func SelectMigrationVersions(ctx context.Context, db *sql.DB, table string) (map[string]backends.MigrationRecord, error) {
+	versions, err := QueryColumn(ctx, db, fmt.Sprintf("select version from %s", table))
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := map[string]backends.MigrationRecord{}
+	for _, version := range versions {
+		migrations[version] = backends.MigrationRecord{Version: version}
+	}
+	return migrations, nil
+}
+
+// DumpMigrations renders the applied migrations of table as an insert statement restoring
+// every column, quote renders string literals and timeLayout the applied_at timestamps in UTC
+func DumpMigrations(ctx context.Context, db *sql.DB, table string, quote func(string) string, timeLayout string) ([]byte, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("select %s from %s", MigrationsColumns, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migrations, err := ScanMigrations(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(migrations))
+	for version := range migrations {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	values := make([]string, 0, len(versions))
+	for _, version := range versions {
+		record := migrations[version]
+		appliedAt := "NULL"
+		if !record.AppliedAt.IsZero() {
+			appliedAt = quote(record.AppliedAt.UTC().Format(timeLayout))
+		}
+		values = append(values, strings.Join([]string{
+			quote(record.Version),
+			quote(record.Description),
+			quote(record.Checksum),
+			appliedAt,
+			quote(record.AppliedBy),
+			quote(record.Hostname),
+			strconv.FormatInt(record.ExecutionTime.Milliseconds(), 10),
+			strconv.FormatBool(record.Dirty),
+		}, ", "))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\n--\n-- Migrator schema migrations\n--\n\n")
+	if len(values) > 0 {
+		buf.WriteString(fmt.Sprintf("INSERT INTO %s (%s) VALUES\n    (", table, MigrationsColumns))
+		buf.WriteString(strings.Join(values, "),\n    ("))
+		buf.WriteString(");\n")
+	}
+
+	return buf.Bytes(), nil
+}
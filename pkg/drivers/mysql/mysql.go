@@ -1,30 +1,55 @@
 package mysql
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/hchenc/migrator/pkg/backends"
 	"github.com/hchenc/migrator/pkg/drivers"
+	merrors "github.com/hchenc/migrator/pkg/errors"
 	"github.com/hchenc/migrator/pkg/utils"
 
-	_ "github.com/go-sql-driver/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
 )
 
+// killTimeout is how long KillOnCancel waits for KILL QUERY to complete
+const killTimeout = 10 * time.Second
+
+// autoIncrementRegExp matches the table option holding the current auto increment counter
+var autoIncrementRegExp = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// definerRegExp matches the account clause of views, routines and triggers
+var definerRegExp = regexp.MustCompile("DEFINER=`(?:[^`]|``)*`@`(?:[^`]|``)*` ")
+
+// identifierRegExp matches charset and collation names which can't be quoted
+var identifierRegExp = regexp.MustCompile(`^\w+$`)
+
 func init() {
 	drivers.RegisterDriver(newMysqlDriver, "mysql")
 }
 
 func newMysqlDriver(config *backends.BackendConfig) drivers.DriverService {
-	config.MigrationsTable = utils.FormateDatabaseStr(config.MigrationsTable)
+	tableName := config.MigrationsTable
+	config.MigrationsTable = quoteIdentifier(config.MigrationsTable)
 	return &mysqlDriver{
 		config:     config,
 		driverType: "mysql",
+		tableName:  tableName,
 	}
 }
 
 type mysqlDriver struct {
 	config     *backends.BackendConfig
 	driverType drivers.DriverType
+	// tableName is the unquoted migrations table name
+	tableName string
 }
 
 func (m *mysqlDriver) Open() (*sql.DB, error) {
@@ -39,6 +64,8 @@ func (m *mysqlDriver) getConn(schema string) string {
 	}
 	query := m.config.DatabaseUrl.Query()
 	query.Set("multiStatements", "true")
+	// scan migrations table timestamps into time.Time
+	query.Set("parseTime", "true")
 
 	host := m.config.DatabaseUrl.Host
 	protocol := "tcp"
@@ -69,7 +96,7 @@ func (m *mysqlDriver) getConn(schema string) string {
 	return connStr
 }
 
-func (m *mysqlDriver) SchemaExists() (bool, error) {
+func (m *mysqlDriver) SchemaExists(ctx context.Context) (bool, error) {
 	schema := utils.GetSchameName(m.config.DatabaseUrl)
 	db, err := sql.Open(string(m.driverType), m.getConn("/"))
 	defer db.Close()
@@ -78,7 +105,7 @@ func (m *mysqlDriver) SchemaExists() (bool, error) {
 	}
 
 	exists := false
-	err = db.QueryRow("select true from information_schema.schemata "+
+	err = db.QueryRowContext(ctx, "select true from information_schema.schemata "+
 		"where schema_name = ?", schema).Scan(&exists)
 	if err == sql.ErrNoRows {
 		return false, nil
@@ -88,76 +115,381 @@ func (m *mysqlDriver) SchemaExists() (bool, error) {
 
 }
 
-func (m *mysqlDriver) CreateSchema() error {
-	panic("implement me")
+func (m *mysqlDriver) CreateSchema(ctx context.Context) error {
+	schema := utils.GetSchameName(m.config.DatabaseUrl)
+	db, err := sql.Open(string(m.driverType), m.getConn("/"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := fmt.Sprintf("create database %s", quoteIdentifier(schema))
+
+	// charset may list fallbacks, e.g. charset=utf8mb4,utf8
+	urlQuery := m.config.DatabaseUrl.Query()
+	if charset := strings.Split(urlQuery.Get("charset"), ",")[0]; charset != "" {
+		if !identifierRegExp.MatchString(charset) {
+			return fmt.Errorf("invalid charset `%s`", charset)
+		}
+		query = fmt.Sprintf("%s character set %s", query, charset)
+	}
+	if collation := urlQuery.Get("collation"); collation != "" {
+		if !identifierRegExp.MatchString(collation) {
+			return fmt.Errorf("invalid collation `%s`", collation)
+		}
+		query = fmt.Sprintf("%s collate %s", query, collation)
+	}
+
+	_, err = db.ExecContext(ctx, query)
+	return err
 }
 
-func (m *mysqlDriver) DropSchema() error {
-	panic("implement me")
+func (m *mysqlDriver) DropSchema(ctx context.Context) error {
+	schema := utils.GetSchameName(m.config.DatabaseUrl)
+	db, err := sql.Open(string(m.driverType), m.getConn("/"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("drop database if exists %s", quoteIdentifier(schema)))
+	return err
+}
+
+func (m *mysqlDriver) DumpSchema(ctx context.Context, db *sql.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("SET FOREIGN_KEY_CHECKS = 0;\n")
+
+	// tables and views, tables first so that views can reference them
+	for _, tableType := range []string{"BASE TABLE", "VIEW"} {
+		names, err := drivers.QueryColumn(ctx, db, "select table_name from information_schema.tables "+
+			"where table_schema = database() and table_type = ? order by table_name", tableType)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			stmt, err := m.showCreate(ctx, db, "table", name)
+			if err != nil {
+				return nil, err
+			}
+			if tableType == "BASE TABLE" {
+				stmt = autoIncrementRegExp.ReplaceAllString(stmt, "")
+			} else {
+				stmt = definerRegExp.ReplaceAllString(stmt, "")
+			}
+			buf.WriteString("\n")
+			buf.WriteString(stmt)
+			buf.WriteString(";\n")
+		}
+	}
+
+	// stored routines and triggers contain statement delimiters in their body
+	for _, routineType := range []string{"FUNCTION", "PROCEDURE"} {
+		names, err := drivers.QueryColumn(ctx, db, "select routine_name from information_schema.routines "+
+			"where routine_schema = database() and routine_type = ? order by routine_name", routineType)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			stmt, err := m.showCreate(ctx, db, strings.ToLower(routineType), name)
+			if err != nil {
+				return nil, err
+			}
+			writeDelimited(&buf, definerRegExp.ReplaceAllString(stmt, ""))
+		}
+	}
+
+	triggers, err := drivers.QueryColumn(ctx, db, "select trigger_name from information_schema.triggers "+
+		"where trigger_schema = database() order by event_object_table, action_order, trigger_name")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range triggers {
+		stmt, err := m.showCreate(ctx, db, "trigger", name)
+		if err != nil {
+			return nil, err
+		}
+		writeDelimited(&buf, definerRegExp.ReplaceAllString(stmt, ""))
+	}
+
+	buf.WriteString("\nSET FOREIGN_KEY_CHECKS = 1;\n")
+
+	// timestamps are stored in UTC without offset by the mysql driver
+	migrations, err := drivers.DumpMigrations(ctx, db, m.config.MigrationsTable, quoteLiteral, "2006-01-02 15:04:05.999999")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf.Bytes(), migrations...), nil
+}
+
+// showCreate returns the definition of a database object using SHOW CREATE,
+// the statement column is looked up by name as its position differs per object type
+func (m *mysqlDriver) showCreate(ctx context.Context, db *sql.DB, objectType, name string) (string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("show create %s %s", objectType, quoteIdentifier(name)))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("unable to show create %s `%s`", objectType, name)
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return "", err
+	}
+
+	for i, column := range columns {
+		if strings.HasPrefix(column, "Create ") || column == "SQL Original Statement" {
+			return values[i].String, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to show create %s `%s`", objectType, name)
 }
 
-func (m *mysqlDriver) DumpSchema(db *sql.DB) ([]byte, error) {
-	panic("implement me")
+// migrationsColumns are the migrations table columns besides version
+var migrationsColumns = []drivers.Column{
+	{Name: "description", Definition: "varchar(255) character set utf8mb4 not null default ''"},
+	{Name: "checksum", Definition: "varchar(64) not null default ''"},
+	{Name: "applied_at", Definition: "timestamp null default null"},
+	{Name: "applied_by", Definition: "varchar(255) character set utf8mb4 not null default ''"},
+	{Name: "hostname", Definition: "varchar(255) character set utf8mb4 not null default ''"},
+	{Name: "execution_time", Definition: "bigint not null default 0"},
+	{Name: "dirty", Definition: "boolean not null default false"},
 }
 
-func (m *mysqlDriver) CreateMigrationsTable(db *sql.DB) error {
-	_, err := db.Exec(
+func (m *mysqlDriver) CreateMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx,
 		fmt.Sprintf("create table if not exists %s "+
 			"(version varchar(255) primary key) character set latin1 collate latin1_bin", m.config.MigrationsTable))
-	return err
+	if err != nil {
+		return err
+	}
+
+	// upgrade tables created by older versions in place
+	existing, err := m.MigrationsTableColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+	return drivers.AddMissingColumns(ctx, db, m.config.MigrationsTable, existing, migrationsColumns)
 }
 
-func (m *mysqlDriver) SelectMigrations(db *sql.DB, id int) (map[string]bool, error) {
-	query := fmt.Sprintf("select version from %s order by version desc", m.config.MigrationsTable)
+func (m *mysqlDriver) MigrationsTableColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	return drivers.QueryColumn(ctx, db, "select column_name from information_schema.columns "+
+		"where table_schema = database() and table_name = ?", m.tableName)
+}
+
+func (m *mysqlDriver) SelectMigrationVersions(ctx context.Context, db *sql.DB) (map[string]backends.MigrationRecord, error) {
+	return drivers.SelectMigrationVersions(ctx, db, m.config.MigrationsTable)
+}
+
+func (m *mysqlDriver) SelectMigrations(ctx context.Context, db *sql.DB, id int) (map[string]backends.MigrationRecord, error) {
+	query := fmt.Sprintf("select %s from %s order by version desc", drivers.MigrationsColumns, m.config.MigrationsTable)
 
 	if id >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, id)
 	}
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
+	return drivers.ScanMigrations(rows)
+}
+
+func (m *mysqlDriver) InsertMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("insert into %s (%s) values (?, ?, ?, ?, ?, ?, ?, ?)", m.config.MigrationsTable, drivers.MigrationsColumns),
+		record.Version, record.Description, record.Checksum, record.AppliedAt,
+		record.AppliedBy, record.Hostname, record.ExecutionTime.Milliseconds(), record.Dirty)
+
+	return err
+}
+
+func (m *mysqlDriver) UpdateMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("update %s set description = ?, checksum = ?, applied_at = ?, applied_by = ?, "+
+			"hostname = ?, execution_time = ?, dirty = ? where version = ?", m.config.MigrationsTable),
+		record.Description, record.Checksum, record.AppliedAt, record.AppliedBy,
+		record.Hostname, record.ExecutionTime.Milliseconds(), record.Dirty, record.Version)
+
+	return err
+}
+
+func (m *mysqlDriver) DeleteMigration(ctx context.Context, tx backends.Transaction, version string) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("delete from %s where version = ?", m.config.MigrationsTable),
+		version)
+
+	return err
+}
+
+// lockName returns the GET_LOCK name of the migrations table, limited to 64 characters
+func (m *mysqlDriver) lockName() string {
+	name := fmt.Sprintf("migrator:%s.%s", utils.GetSchameName(m.config.DatabaseUrl), m.tableName)
+	if len(name) > 64 {
+		name = "migrator:" + utils.Checksum(name)[:55]
+	}
+	return name
+}
+
+func (m *mysqlDriver) Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (func() error, error) {
+	name := m.lockName()
+
+	// named locks belong to a session, so keep a dedicated connection until released
+	conn, err := db.Conn(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	migrations := map[string]bool{}
-	for rows.Next() {
-		var version string
-		if err := rows.Scan(&version); err != nil {
+	// try without waiting first so that the lock holder can be reported
+	var acquired sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "select get_lock(?, 0)", name).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if acquired.Int64 != 1 {
+		holder := "another migrator"
+		var user sql.NullString
+		if err := conn.QueryRowContext(ctx, "select concat(user, '@', host) from information_schema.processlist "+
+			"where id = is_used_lock(?)", name).Scan(&user); err == nil && user.Valid {
+			holder = user.String
+		}
+		fmt.Fprintf(m.config.Log, "Waiting for lock held by %s\n", holder)
+
+		seconds := -1
+		if timeout > 0 {
+			seconds = int(math.Ceil(timeout.Seconds()))
+		}
+		if err = conn.QueryRowContext(ctx, "select get_lock(?, ?)", name, seconds).Scan(&acquired); err != nil {
+			conn.Close()
 			return nil, err
 		}
-		migrations[version] = true
+		if acquired.Int64 != 1 {
+			conn.Close()
+			return nil, fmt.Errorf("%w `%s` held by %s after %s", merrors.ErrLockTimeout, name, holder, timeout)
+		}
 	}
 
-	if err = rows.Err(); err != nil {
+	return func() error {
+		defer conn.Close()
+		// release even when ctx was cancelled, closing the session would release it anyway
+		_, err := conn.ExecContext(context.Background(), "select release_lock(?)", name)
+		return err
+	}, nil
+}
+
+// SetLockWaitTimeout sets lock_wait_timeout, which covers metadata locks taken by DDL,
+// and innodb_lock_wait_timeout, which covers row locks. Both are in whole seconds.
+func (m *mysqlDriver) SetLockWaitTimeout(ctx context.Context, tx backends.Transaction, timeout time.Duration) (func() error, error) {
+	var lockWait, innodbLockWait int64
+	if err := tx.QueryRowContext(ctx, "select @@session.lock_wait_timeout, @@session.innodb_lock_wait_timeout").
+		Scan(&lockWait, &innodbLockWait); err != nil {
+		return nil, err
+	}
+
+	seconds := int64(math.Ceil(timeout.Seconds()))
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("set session lock_wait_timeout = %d, innodb_lock_wait_timeout = %d",
+		seconds, seconds)); err != nil {
 		return nil, err
 	}
 
-	return migrations, nil
+	return func() error {
+		_, err := tx.ExecContext(context.Background(), fmt.Sprintf("set session lock_wait_timeout = %d, innodb_lock_wait_timeout = %d",
+			lockWait, innodbLockWait))
+		return err
+	}, nil
 }
 
-func (m *mysqlDriver) InsertMigration(tx backends.Transaction, version string) error {
-	_, err := tx.Exec(
-		fmt.Sprintf("insert into %s (version) values (?)", m.config.MigrationsTable),
-		version)
+// KillOnCancel sends KILL QUERY from another connection once ctx is done, as the driver only
+// closes the socket and the statement would otherwise keep running and holding its locks
+func (m *mysqlDriver) KillOnCancel(ctx context.Context, db *sql.DB, tx backends.Transaction) (func(), error) {
+	var id int64
+	if err := tx.QueryRowContext(ctx, "select connection_id()").Scan(&id); err != nil {
+		return nil, err
+	}
 
-	return err
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			killCtx, cancel := context.WithTimeout(context.Background(), killTimeout)
+			defer cancel()
+			if _, err := db.ExecContext(killCtx, fmt.Sprintf("kill query %d", id)); err != nil {
+				fmt.Fprintf(m.config.Log, "Failed to kill query of connection %d: %s\n", id, err)
+			}
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}, nil
 }
 
-func (m *mysqlDriver) DeleteMigration(tx backends.Transaction, version string) error {
-	_, err := tx.Exec(
-		fmt.Sprintf("delete from %s where version = ?", m.config.MigrationsTable),
-		version)
+// TransactionalDDL is false, DDL statements implicitly commit the transaction
+func (m *mysqlDriver) TransactionalDDL() bool {
+	return false
+}
 
-	return err
+// IsRetryable retries deadlocks (1213) and lost connections
+func (m *mysqlDriver) IsRetryable(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213
+	}
+	return drivers.IsBadConn(err) || errors.Is(err, mysqldriver.ErrInvalidConn)
 }
 
-func (m *mysqlDriver) Ping() error {
+func (m *mysqlDriver) Ping(ctx context.Context) error {
 	db, err := sql.Open(string(m.driverType), m.getConn("/"))
 	defer db.Close()
 	if err != nil {
 		return err
 	}
 
-	return db.Ping()
+	return db.PingContext(ctx)
+}
+
+func (m *mysqlDriver) Dialect() utils.Dialect {
+	return utils.DialectMysql
+}
+
+// writeDelimited writes a compound statement surrounded by DELIMITER directives
+func writeDelimited(buf *bytes.Buffer, stmt string) {
+	buf.WriteString("\nDELIMITER ;;\n")
+	buf.WriteString(stmt)
+	buf.WriteString(" ;;\nDELIMITER ;\n")
+}
+
+// quoteIdentifier quotes a database, table or routine name, doubling the backticks it contains
+func quoteIdentifier(str string) string {
+	str = strings.Replace(str, "`", "``", -1)
+	return fmt.Sprintf("`%s`", str)
+}
+
+func quoteLiteral(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	str = strings.Replace(str, `'`, `\'`, -1)
+	return fmt.Sprintf("'%s'", str)
 }
@@ -0,0 +1,416 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/drivers"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+	"github.com/hchenc/migrator/pkg/utils"
+
+	"github.com/lib/pq"
+)
+
+// lockPollInterval is the delay between two attempts to acquire the advisory lock
+const lockPollInterval = time.Second
+
+func init() {
+	drivers.RegisterDriver(newPostgresDriver, "postgres")
+	drivers.RegisterDriver(newPostgresDriver, "postgresql")
+}
+
+func newPostgresDriver(config *backends.BackendConfig) drivers.DriverService {
+	p := &postgresDriver{
+		config:     config,
+		driverType: "postgres",
+	}
+	p.migrationsSchema, p.migrationsTable = p.migrationsTableParts()
+	return p
+}
+
+type postgresDriver struct {
+	config           *backends.BackendConfig
+	driverType       drivers.DriverType
+	migrationsSchema string
+	migrationsTable  string
+}
+
+func (p *postgresDriver) Open() (*sql.DB, error) {
+	return sql.Open(string(p.driverType), p.getConn(""))
+}
+
+// getConn builds a lib/pq connection url, optionally connecting to another database
+func (p *postgresDriver) getConn(schema string) string {
+	u := p.config.DatabaseUrl
+	hostname := u.Hostname()
+	port := u.Port()
+	query := u.Query()
+
+	// support socket parameter for consistency with mysql
+	if query.Get("socket") != "" {
+		query.Set("host", query.Get("socket"))
+		query.Del("socket")
+	}
+
+	// default hostname
+	if hostname == "" && query.Get("host") == "" {
+		switch runtime.GOOS {
+		case "linux":
+			query.Set("host", "/var/run/postgresql")
+		case "darwin", "freebsd", "dragonfly", "openbsd", "netbsd":
+			query.Set("host", "/tmp")
+		default:
+			hostname = "localhost"
+		}
+	}
+
+	// host param overrides url hostname
+	if query.Get("host") != "" {
+		hostname = ""
+	}
+
+	// always specify a port
+	if query.Get("port") != "" {
+		port = query.Get("port")
+		query.Del("port")
+	}
+	if port == "" {
+		port = "5432"
+	}
+
+	out := &url.URL{
+		Scheme:   "postgres",
+		Host:     fmt.Sprintf("%s:%s", hostname, port),
+		Path:     u.Path,
+		RawQuery: query.Encode(),
+	}
+	if schema != "" {
+		out.Path = schema
+	}
+
+	// user:pass from config take precedence over url userinfo
+	if p.config.DatabaseUser != "" {
+		out.User = url.UserPassword(p.config.DatabaseUser, p.config.DatabasePass)
+	} else if u.User != nil {
+		out.User = u.User
+	}
+
+	return out.String()
+}
+
+// getDumpConn removes parameters which libpq tools such as pg_dump do not understand
+func (p *postgresDriver) getDumpConn() string {
+	u, _ := url.Parse(p.getConn(""))
+	query := u.Query()
+	query.Del("search_path")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// searchPath returns the schemas listed in the search_path url parameter
+func (p *postgresDriver) searchPath() []string {
+	var schemas []string
+	for _, schema := range strings.Split(p.config.DatabaseUrl.Query().Get("search_path"), ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+// migrationsTableParts splits the migrations table into schema and table name,
+// falling back to the first schema of search_path when the table is unqualified
+func (p *postgresDriver) migrationsTableParts() (string, string) {
+	table := p.config.MigrationsTable
+	if parts := strings.SplitN(table, ".", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	if schemas := p.searchPath(); len(schemas) > 0 {
+		return schemas[0], table
+	}
+	return "", table
+}
+
+// quotedMigrationsTable returns the fully quoted migrations table name
+func (p *postgresDriver) quotedMigrationsTable() string {
+	if p.migrationsSchema == "" {
+		return pq.QuoteIdentifier(p.migrationsTable)
+	}
+	return pq.QuoteIdentifier(p.migrationsSchema) + "." + pq.QuoteIdentifier(p.migrationsTable)
+}
+
+func (p *postgresDriver) SchemaExists(ctx context.Context) (bool, error) {
+	schema := utils.GetSchameName(p.config.DatabaseUrl)
+	db, err := sql.Open(string(p.driverType), p.getConn("postgres"))
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	exists := false
+	err = db.QueryRowContext(ctx, "select true from pg_database where datname = $1", schema).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+func (p *postgresDriver) CreateSchema(ctx context.Context) error {
+	schema := utils.GetSchameName(p.config.DatabaseUrl)
+	db, err := sql.Open(string(p.driverType), p.getConn("postgres"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("create database %s", pq.QuoteIdentifier(schema)))
+	return err
+}
+
+func (p *postgresDriver) DropSchema(ctx context.Context) error {
+	schema := utils.GetSchameName(p.config.DatabaseUrl)
+	db, err := sql.Open(string(p.driverType), p.getConn("postgres"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("drop database if exists %s", pq.QuoteIdentifier(schema)))
+	return err
+}
+
+func (p *postgresDriver) DumpSchema(ctx context.Context, db *sql.DB) ([]byte, error) {
+	// load schema
+	args := []string{"--format=plain", "--encoding=UTF8", "--schema-only", "--no-privileges", "--no-owner"}
+	for _, schema := range p.searchPath() {
+		args = append(args, "--schema", schema)
+	}
+	args = append(args, p.getDumpConn())
+
+	schema, err := utils.RunCommandContext(ctx, "pg_dump", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := drivers.DumpMigrations(ctx, db, p.quotedMigrationsTable(), pq.QuoteLiteral, "2006-01-02 15:04:05.999999999-07:00")
+	if err != nil {
+		return nil, err
+	}
+
+	schema = append(schema, migrations...)
+	return utils.TrimLeadingSQLComments(schema)
+}
+
+// migrationsColumns are the migrations table columns besides version
+var migrationsColumns = []drivers.Column{
+	{Name: "description", Definition: "varchar(255) not null default ''"},
+	{Name: "checksum", Definition: "varchar(64) not null default ''"},
+	{Name: "applied_at", Definition: "timestamp with time zone"},
+	{Name: "applied_by", Definition: "varchar(255) not null default ''"},
+	{Name: "hostname", Definition: "varchar(255) not null default ''"},
+	{Name: "execution_time", Definition: "bigint not null default 0"},
+	{Name: "dirty", Definition: "boolean not null default false"},
+}
+
+func (p *postgresDriver) CreateMigrationsTable(ctx context.Context, db *sql.DB) error {
+	if p.migrationsSchema != "" {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("create schema if not exists %s", pq.QuoteIdentifier(p.migrationsSchema))); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.ExecContext(ctx,
+		fmt.Sprintf("create table if not exists %s "+
+			"(version varchar(255) primary key)", p.quotedMigrationsTable()))
+	if err != nil {
+		return err
+	}
+
+	// upgrade tables created by older versions in place
+	existing, err := p.MigrationsTableColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+	return drivers.AddMissingColumns(ctx, db, p.quotedMigrationsTable(), existing, migrationsColumns)
+}
+
+func (p *postgresDriver) MigrationsTableColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	return drivers.QueryColumn(ctx, db, "select column_name from information_schema.columns "+
+		"where table_schema = coalesce(nullif($1, ''), current_schema()) and table_name = $2",
+		p.migrationsSchema, p.migrationsTable)
+}
+
+func (p *postgresDriver) SelectMigrationVersions(ctx context.Context, db *sql.DB) (map[string]backends.MigrationRecord, error) {
+	return drivers.SelectMigrationVersions(ctx, db, p.quotedMigrationsTable())
+}
+
+func (p *postgresDriver) SelectMigrations(ctx context.Context, db *sql.DB, id int) (map[string]backends.MigrationRecord, error) {
+	query := fmt.Sprintf("select %s from %s order by version desc", drivers.MigrationsColumns, p.quotedMigrationsTable())
+
+	if id >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, id)
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return drivers.ScanMigrations(rows)
+}
+
+func (p *postgresDriver) InsertMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("insert into %s (%s) values ($1, $2, $3, $4, $5, $6, $7, $8)", p.quotedMigrationsTable(), drivers.MigrationsColumns),
+		record.Version, record.Description, record.Checksum, record.AppliedAt,
+		record.AppliedBy, record.Hostname, record.ExecutionTime.Milliseconds(), record.Dirty)
+
+	return err
+}
+
+func (p *postgresDriver) UpdateMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("update %s set description = $1, checksum = $2, applied_at = $3, applied_by = $4, "+
+			"hostname = $5, execution_time = $6, dirty = $7 where version = $8", p.quotedMigrationsTable()),
+		record.Description, record.Checksum, record.AppliedAt, record.AppliedBy,
+		record.Hostname, record.ExecutionTime.Milliseconds(), record.Dirty, record.Version)
+
+	return err
+}
+
+func (p *postgresDriver) DeleteMigration(ctx context.Context, tx backends.Transaction, version string) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("delete from %s where version = $1", p.quotedMigrationsTable()),
+		version)
+
+	return err
+}
+
+// lockKey returns the advisory lock key of the migrations table
+func (p *postgresDriver) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("migrator:%s.%s", utils.GetSchameName(p.config.DatabaseUrl), p.quotedMigrationsTable())))
+	return int64(h.Sum64())
+}
+
+func (p *postgresDriver) Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (func() error, error) {
+	key := p.lockKey()
+
+	// advisory locks belong to a session, so keep a dedicated connection until released
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for waiting := false; ; waiting = true {
+		var acquired bool
+		if err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+
+		if !waiting {
+			fmt.Fprintf(p.config.Log, "Waiting for lock held by %s\n", p.lockHolder(ctx, conn, key))
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			conn.Close()
+			return nil, fmt.Errorf("%w %d after %s", merrors.ErrLockTimeout, key, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	return func() error {
+		defer conn.Close()
+		// release even when ctx was cancelled, closing the session would release it anyway
+		_, err := conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", key)
+		return err
+	}, nil
+}
+
+// lockHolder describes the session holding the advisory lock
+func (p *postgresDriver) lockHolder(ctx context.Context, conn *sql.Conn, key int64) string {
+	var holder string
+	// a bigint advisory key is split into classid (high bits) and objid (low bits)
+	err := conn.QueryRowContext(ctx, "select a.usename || '@' || coalesce(a.client_hostname, host(a.client_addr), 'local') "+
+		"|| ' (pid ' || a.pid || ')' from pg_locks l join pg_stat_activity a on a.pid = l.pid "+
+		"where l.locktype = 'advisory' and l.granted and l.objsubid = 1 "+
+		"and l.classid::bigint = $1 and l.objid::bigint = $2",
+		int64(uint64(key)>>32), int64(uint32(key))).Scan(&holder)
+	if err != nil {
+		return "another migrator"
+	}
+	return holder
+}
+
+// SetLockWaitTimeout sets lock_timeout, which a failed transaction resets on its own
+func (p *postgresDriver) SetLockWaitTimeout(ctx context.Context, tx backends.Transaction, timeout time.Duration) (func() error, error) {
+	var previous string
+	if err := tx.QueryRowContext(ctx, "select current_setting('lock_timeout')").Scan(&previous); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "select set_config('lock_timeout', $1, false)",
+		fmt.Sprintf("%dms", timeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := tx.ExecContext(context.Background(), "select set_config('lock_timeout', $1, false)", previous)
+		return err
+	}, nil
+}
+
+// KillOnCancel does nothing, the driver sends a cancel request to the server once ctx is done
+func (p *postgresDriver) KillOnCancel(ctx context.Context, db *sql.DB, tx backends.Transaction) (func(), error) {
+	return func() {}, nil
+}
+
+// TransactionalDDL is true, DDL statements are transactional
+func (p *postgresDriver) TransactionalDDL() bool {
+	return true
+}
+
+// IsRetryable retries serialization failures (40001), deadlocks (40P01) and connection exceptions (class 08)
+func (p *postgresDriver) IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01" || pqErr.Code.Class() == "08"
+	}
+	return drivers.IsBadConn(err)
+}
+
+func (p *postgresDriver) Ping(ctx context.Context) error {
+	db, err := sql.Open(string(p.driverType), p.getConn("postgres"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.PingContext(ctx)
+}
+
+func (p *postgresDriver) Dialect() utils.Dialect {
+	return utils.DialectPostgres
+}
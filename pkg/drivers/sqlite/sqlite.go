@@ -0,0 +1,348 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hchenc/migrator/pkg/backends"
+	"github.com/hchenc/migrator/pkg/drivers"
+	"github.com/hchenc/migrator/pkg/utils"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const memoryDatabase = ":memory:"
+
+func init() {
+	drivers.RegisterDriver(newSqliteDriver, "sqlite")
+	drivers.RegisterDriver(newSqliteDriver, "sqlite3")
+}
+
+func newSqliteDriver(config *backends.BackendConfig) drivers.DriverService {
+	tableName := config.MigrationsTable
+	config.MigrationsTable = quoteIdentifier(config.MigrationsTable)
+	return &sqliteDriver{
+		config:     config,
+		driverType: "sqlite3",
+		tableName:  tableName,
+		memoryID:   atomic.AddUint64(&memoryDatabases, 1),
+	}
+}
+
+type sqliteDriver struct {
+	config     *backends.BackendConfig
+	driverType drivers.DriverType
+	// tableName is the unquoted migrations table name
+	tableName string
+	// keepalive holds an in-memory database open for the lifetime of the driver,
+	// otherwise it would vanish every time the migrator closes its connection
+	keepalive *sql.DB
+	// memoryID names the in-memory database of the driver, unique within the process
+	memoryID uint64
+}
+
+// memoryDatabases counts the drivers created, so that each in-memory database has its own name
+var memoryDatabases uint64
+
+func (s *sqliteDriver) Open() (*sql.DB, error) {
+	if s.isMemory() && s.keepalive == nil {
+		keepalive, err := s.open()
+		if err != nil {
+			return nil, err
+		}
+		if err = keepalive.Ping(); err != nil {
+			keepalive.Close()
+			return nil, err
+		}
+		s.keepalive = keepalive
+	}
+
+	return s.open()
+}
+
+func (s *sqliteDriver) open() (*sql.DB, error) {
+	db, err := sql.Open(string(s.driverType), s.getConn())
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only supports a single writer, avoid "database is locked" errors
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// getPath returns the database file path from the url, supporting
+// sqlite:relative/path.db, sqlite:///absolute/path.db and sqlite::memory:
+func (s *sqliteDriver) getPath() string {
+	u := s.config.DatabaseUrl
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+
+	// trim duplicate leading slashes
+	return regexp.MustCompile("^//+").ReplaceAllString(path, "/")
+}
+
+func (s *sqliteDriver) isMemory() bool {
+	return s.getPath() == memoryDatabase
+}
+
+func (s *sqliteDriver) getConn() string {
+	query := s.config.DatabaseUrl.Query()
+	path := s.getPath()
+
+	if path == memoryDatabase {
+		// a named shared cache lets every connection of this driver see the same database
+		path = fmt.Sprintf("migrator-%d", s.memoryID)
+		query.Set("mode", "memory")
+		query.Set("cache", "shared")
+	}
+
+	connStr := "file:" + path
+	if len(query) > 0 {
+		connStr = fmt.Sprintf("%s?%s", connStr, query.Encode())
+	}
+	return connStr
+}
+
+func (s *sqliteDriver) SchemaExists(ctx context.Context) (bool, error) {
+	if s.isMemory() {
+		return true, nil
+	}
+
+	_, err := os.Stat(s.getPath())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *sqliteDriver) CreateSchema(ctx context.Context) error {
+	if !s.isMemory() {
+		if err := utils.EnsureDir(filepath.Dir(s.getPath())); err != nil {
+			return err
+		}
+	}
+
+	db, err := s.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// sqlite creates the database file on first connection
+	return db.PingContext(ctx)
+}
+
+func (s *sqliteDriver) DropSchema(ctx context.Context) error {
+	if s.isMemory() {
+		if s.keepalive != nil {
+			err := s.keepalive.Close()
+			s.keepalive = nil
+			return err
+		}
+		return nil
+	}
+
+	err := os.Remove(s.getPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *sqliteDriver) DumpSchema(ctx context.Context, db *sql.DB) ([]byte, error) {
+	// tables first so that indexes, views and triggers can be restored in order
+	rows, err := db.QueryContext(ctx, "select sql from sqlite_master "+
+		"where sql is not null and name not like 'sqlite_%' "+
+		"order by case type when 'table' then 0 when 'index' then 1 when 'view' then 2 else 3 end, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return nil, err
+		}
+		buf.WriteString(stmt)
+		buf.WriteString(";\n")
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	migrations, err := drivers.DumpMigrations(ctx, db, s.config.MigrationsTable, quoteLiteral, "2006-01-02 15:04:05.999999999-07:00")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf.Bytes(), migrations...), nil
+}
+
+// migrationsColumns are the migrations table columns besides version,
+// sqlite can't add columns with a non-constant default so applied_at has none
+var migrationsColumns = []drivers.Column{
+	{Name: "description", Definition: "varchar(255) not null default ''"},
+	{Name: "checksum", Definition: "varchar(64) not null default ''"},
+	{Name: "applied_at", Definition: "timestamp"},
+	{Name: "applied_by", Definition: "varchar(255) not null default ''"},
+	{Name: "hostname", Definition: "varchar(255) not null default ''"},
+	{Name: "execution_time", Definition: "bigint not null default 0"},
+	{Name: "dirty", Definition: "boolean not null default 0"},
+}
+
+func (s *sqliteDriver) CreateMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx,
+		fmt.Sprintf("create table if not exists %s "+
+			"(version varchar(255) primary key)", s.config.MigrationsTable))
+	if err != nil {
+		return err
+	}
+
+	// upgrade tables created by older versions in place
+	existing, err := s.MigrationsTableColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+	return drivers.AddMissingColumns(ctx, db, s.config.MigrationsTable, existing, migrationsColumns)
+}
+
+func (s *sqliteDriver) MigrationsTableColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	return drivers.QueryColumn(ctx, db, fmt.Sprintf("select name from pragma_table_info(%s)", quoteLiteral(s.tableName)))
+}
+
+func (s *sqliteDriver) SelectMigrationVersions(ctx context.Context, db *sql.DB) (map[string]backends.MigrationRecord, error) {
+	return drivers.SelectMigrationVersions(ctx, db, s.config.MigrationsTable)
+}
+
+func (s *sqliteDriver) SelectMigrations(ctx context.Context, db *sql.DB, id int) (map[string]backends.MigrationRecord, error) {
+	query := fmt.Sprintf("select %s from %s order by version desc", drivers.MigrationsColumns, s.config.MigrationsTable)
+
+	if id >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, id)
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return drivers.ScanMigrations(rows)
+}
+
+func (s *sqliteDriver) InsertMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("insert into %s (%s) values (?, ?, ?, ?, ?, ?, ?, ?)", s.config.MigrationsTable, drivers.MigrationsColumns),
+		record.Version, record.Description, record.Checksum, record.AppliedAt,
+		record.AppliedBy, record.Hostname, record.ExecutionTime.Milliseconds(), record.Dirty)
+
+	return err
+}
+
+func (s *sqliteDriver) UpdateMigration(ctx context.Context, tx backends.Transaction, record backends.MigrationRecord) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("update %s set description = ?, checksum = ?, applied_at = ?, applied_by = ?, "+
+			"hostname = ?, execution_time = ?, dirty = ? where version = ?", s.config.MigrationsTable),
+		record.Description, record.Checksum, record.AppliedAt, record.AppliedBy,
+		record.Hostname, record.ExecutionTime.Milliseconds(), record.Dirty, record.Version)
+
+	return err
+}
+
+func (s *sqliteDriver) DeleteMigration(ctx context.Context, tx backends.Transaction, version string) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf("delete from %s where version = ?", s.config.MigrationsTable),
+		version)
+
+	return err
+}
+
+// Lock is a no-op, sqlite is meant for local development where a single migrator runs
+func (s *sqliteDriver) Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// SetLockWaitTimeout sets busy_timeout, how long to wait for another connection to release the database
+func (s *sqliteDriver) SetLockWaitTimeout(ctx context.Context, tx backends.Transaction, timeout time.Duration) (func() error, error) {
+	var previous int64
+	if err := tx.QueryRowContext(ctx, "pragma busy_timeout").Scan(&previous); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("pragma busy_timeout = %d", timeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := tx.ExecContext(context.Background(), fmt.Sprintf("pragma busy_timeout = %d", previous))
+		return err
+	}, nil
+}
+
+// KillOnCancel does nothing, the driver interrupts the running statement once ctx is done
+func (s *sqliteDriver) KillOnCancel(ctx context.Context, db *sql.DB, tx backends.Transaction) (func(), error) {
+	return func() {}, nil
+}
+
+// TransactionalDDL is true, DDL statements are transactional
+func (s *sqliteDriver) TransactionalDDL() bool {
+	return true
+}
+
+// IsRetryable retries when another connection holds the database
+func (s *sqliteDriver) IsRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+func (s *sqliteDriver) Ping(ctx context.Context) error {
+	// a missing database file is not an error, sqlite will create it on demand,
+	// but its directory has to be there
+	if !s.isMemory() {
+		_, err := os.Stat(filepath.Dir(s.getPath()))
+		return err
+	}
+
+	db, err := s.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.PingContext(ctx)
+}
+
+func (s *sqliteDriver) Dialect() utils.Dialect {
+	return utils.DialectSqlite
+}
+
+func quoteIdentifier(str string) string {
+	str = strings.Replace(str, `"`, `""`, -1)
+	return fmt.Sprintf(`"%s"`, str)
+}
+
+func quoteLiteral(str string) string {
+	str = strings.Replace(str, `'`, `''`, -1)
+	return fmt.Sprintf(`'%s'`, str)
+}
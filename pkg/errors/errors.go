@@ -0,0 +1,189 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidUrl is returned when the database url can't be parsed or has no scheme
+	ErrInvalidUrl = errors.New("invalid database url")
+	// ErrUnknownDriver is returned when no driver is registered for the url scheme
+	ErrUnknownDriver = errors.New("unknown database driver")
+	// ErrConnection is returned when the database can't be reached
+	ErrConnection = errors.New("unable to connect to database")
+	// ErrMigrationsDirectory is returned when the migrations directory can't be read
+	ErrMigrationsDirectory = errors.New("could not find migrations directory")
+	// ErrNoMigrations is returned when no migration file matches
+	ErrNoMigrations = errors.New("no migration files found")
+	// ErrInvalidMigration is returned when a migration file can't be parsed
+	ErrInvalidMigration = errors.New("invalid migration")
+	// ErrMigrationFailed is returned when executing a migration fails
+	ErrMigrationFailed = errors.New("migration failed")
+	// ErrNoAppliedMigrations is returned when rolling back without any applied migration
+	ErrNoAppliedMigrations = errors.New("no migrations have been applied")
+	// ErrPendingMigrations is returned when migrations are still pending
+	ErrPendingMigrations = errors.New("pending migrations")
+	// ErrDirty is returned when a previous migration left the database in a dirty state
+	ErrDirty = errors.New("database is dirty")
+	// ErrChecksumMismatch is returned when an applied migration file was modified afterwards
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrLockTimeout is returned when another migrator holds the lock for too long
+	ErrLockTimeout = errors.New("timed out waiting for lock")
+	// ErrOutOfOrder is returned when pending migrations are older than the latest applied one
+	ErrOutOfOrder = errors.New("out of order migrations")
+	// ErrMigrationTimeout is returned when a migration runs longer than its timeout
+	ErrMigrationTimeout = errors.New("migration timed out")
+)
+
+// Process exit codes returned by the migrator command line
+const (
+	// ExitOK means the command succeeded
+	ExitOK = 0
+	// ExitError means an unclassified error occurred, e.g. invalid flags
+	ExitError = 1
+	// ExitInvalidConfig means the database url or driver is invalid
+	ExitInvalidConfig = 2
+	// ExitConnection means the database can't be reached
+	ExitConnection = 3
+	// ExitNoMigrations means the migrations directory or files are missing
+	ExitNoMigrations = 4
+	// ExitInvalidMigration means a migration file can't be parsed
+	ExitInvalidMigration = 5
+	// ExitMigrationFailed means executing a migration failed
+	ExitMigrationFailed = 6
+	// ExitPendingMigrations means migrations are still pending
+	ExitPendingMigrations = 7
+	// ExitDirty means the database is in a dirty state
+	ExitDirty = 8
+	// ExitChecksumMismatch means applied migration files were modified
+	ExitChecksumMismatch = 9
+	// ExitLockTimeout means another migrator held the lock for too long
+	ExitLockTimeout = 10
+	// ExitOutOfOrder means pending migrations are older than the latest applied one
+	ExitOutOfOrder = 11
+	// ExitMigrationTimeout means a migration ran longer than its timeout
+	ExitMigrationTimeout = 12
+	// ExitCancelled means the command was interrupted by SIGINT or SIGTERM
+	ExitCancelled = 130
+)
+
+// exitCodes maps each error to its exit code, in order of precedence
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	// the error of an interrupted command may come from any step
+	{context.Canceled, ExitCancelled},
+	{ErrInvalidUrl, ExitInvalidConfig},
+	{ErrUnknownDriver, ExitInvalidConfig},
+	{ErrConnection, ExitConnection},
+	{ErrMigrationsDirectory, ExitNoMigrations},
+	{ErrNoMigrations, ExitNoMigrations},
+	{ErrInvalidMigration, ExitInvalidMigration},
+	{ErrMigrationTimeout, ExitMigrationTimeout},
+	{ErrMigrationFailed, ExitMigrationFailed},
+	{ErrPendingMigrations, ExitPendingMigrations},
+	{ErrDirty, ExitDirty},
+	{ErrChecksumMismatch, ExitChecksumMismatch},
+	{ErrLockTimeout, ExitLockTimeout},
+	{ErrOutOfOrder, ExitOutOfOrder},
+}
+
+// ExitCode returns the process exit code for err
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ExitError
+}
+
+// ConnectionError wraps a driver error raised while connecting to the database
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrConnection, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConnectionError) Is(target error) bool {
+	return target == ErrConnection
+}
+
+// MigrationError wraps a driver error raised while executing a migration
+type MigrationError struct {
+	Filename  string
+	Statement string
+	// Index is the position of the failing statement in the migration block, from 1
+	Index int
+	// Line is the line of the migration file the failing statement starts on
+	Line int
+	Err  error
+}
+
+func (e *MigrationError) Error() string {
+	if e.Index > 0 {
+		return fmt.Sprintf("%s: %s: statement %d at line %d: %s", ErrMigrationFailed, e.Filename, e.Index, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrMigrationFailed, e.Filename, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MigrationError) Is(target error) bool {
+	return target == ErrMigrationFailed
+}
+
+// DirtyError reports the version left dirty by a failed migration
+type DirtyError struct {
+	Version string
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("%s: version %s failed halfway, fix it manually then run `migrator repair %s`",
+		ErrDirty, e.Version, e.Version)
+}
+
+func (e *DirtyError) Is(target error) bool {
+	return target == ErrDirty
+}
+
+// ChecksumError lists the applied migration files whose contents no longer match
+type ChecksumError struct {
+	Filenames []string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrChecksumMismatch, strings.Join(e.Filenames, ", "))
+}
+
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// OutOfOrderError lists the pending migration files older than the latest applied migration
+type OutOfOrderError struct {
+	Filenames []string
+}
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrOutOfOrder, strings.Join(e.Filenames, ", "))
+}
+
+func (e *OutOfOrderError) Is(target error) bool {
+	return target == ErrOutOfOrder
+}
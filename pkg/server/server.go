@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hchenc/migrator/pkg/client"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+)
+
+// Server exposes the migrator operations over http
+type Server struct {
+	migrator *client.Migrator
+	token    string
+	// running holds a value while an operation runs, ensuring only one runs at a time
+	running chan struct{}
+}
+
+// operation runs a migrator operation and returns its result, it is cancelled with the request
+// context, i.e. when the client disconnects or the server is interrupted
+type operation func(r *http.Request) (interface{}, error)
+
+// response is the body of every api response
+type response struct {
+	Result   interface{} `json:"result,omitempty"`
+	Log      []string    `json:"log"`
+	Error    string      `json:"error,omitempty"`
+	ExitCode int         `json:"exit_code"`
+}
+
+// NewServer returns a server driving migrator, requests must carry token unless it is empty
+func NewServer(migrator *client.Migrator, token string) *Server {
+	return &Server{migrator: migrator, token: token, running: make(chan struct{}, 1)}
+}
+
+// Handler returns the http handler serving the api
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handle(http.MethodGet, s.status))
+	mux.HandleFunc("/history", s.handle(http.MethodGet, s.history))
+	mux.HandleFunc("/migrate", s.handle(http.MethodPost, s.migrate))
+	mux.HandleFunc("/up", s.handle(http.MethodPost, s.up))
+	mux.HandleFunc("/down", s.handle(http.MethodPost, s.down))
+	mux.HandleFunc("/rollback", s.handle(http.MethodPost, s.rollback))
+	return s.authenticate(mux)
+}
+
+// bearerPrefix prefixes the token of the Authorization header
+const bearerPrefix = "Bearer "
+
+// authenticate rejects requests without the bearer token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, bearerPrefix) ||
+				subtle.ConstantTimeCompare([]byte(header[len(bearerPrefix):]), []byte(s.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, response{Error: "invalid or missing api token", ExitCode: merrors.ExitError})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handle runs op unless another operation is running, capturing the migrator output into the response
+func (s *Server) handle(method string, op operation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, response{Error: fmt.Sprintf("method %s not allowed", r.Method), ExitCode: merrors.ExitError})
+			return
+		}
+
+		select {
+		case s.running <- struct{}{}:
+			defer func() { <-s.running }()
+		default:
+			writeJSON(w, http.StatusConflict, response{Error: "another operation is running", ExitCode: merrors.ExitError})
+			return
+		}
+
+		var buf bytes.Buffer
+		log := s.migrator.Log
+		s.migrator.Log = &buf
+		defer func() { s.migrator.Log = log }()
+
+		result, err := op(r)
+		resp := response{Result: result, Log: splitLines(buf.String())}
+		if err != nil {
+			resp.Error = err.Error()
+			resp.ExitCode = merrors.ExitCode(err)
+			writeJSON(w, statusCode(err), resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (s *Server) status(r *http.Request) (interface{}, error) {
+	return s.migrator.StatusContext(r.Context())
+}
+
+func (s *Server) history(r *http.Request) (interface{}, error) {
+	return s.migrator.HistoryContext(r.Context())
+}
+
+func (s *Server) migrate(r *http.Request) (interface{}, error) {
+	if dryRun(r) {
+		return s.migrator.PlanContext(r.Context())
+	}
+	return s.migrator.MigrateContext(r.Context())
+}
+
+func (s *Server) up(r *http.Request) (interface{}, error) {
+	step, err := stepParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun(r) {
+		return s.migrator.PlanUpContext(r.Context(), step)
+	}
+	return s.migrator.UpContext(r.Context(), step)
+}
+
+func (s *Server) down(r *http.Request) (interface{}, error) {
+	step, err := stepParam(r)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun(r) {
+		return s.migrator.PlanDownContext(r.Context(), step)
+	}
+	return s.migrator.DownContext(r.Context(), step)
+}
+
+func (s *Server) rollback(r *http.Request) (interface{}, error) {
+	if dryRun(r) {
+		return s.migrator.PlanDownContext(r.Context(), 1)
+	}
+	return s.migrator.RollbackContext(r.Context())
+}
+
+// errBadRequest is returned for invalid query parameters
+var errBadRequest = errors.New("bad request")
+
+// stepParam parses the step query parameter, 1 by default like the command line
+func stepParam(r *http.Request) (uint, error) {
+	value := r.URL.Query().Get("step")
+	if value == "" {
+		return 1, nil
+	}
+	step, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || step == 0 {
+		return 0, fmt.Errorf("%w: invalid step `%s`", errBadRequest, value)
+	}
+	return uint(step), nil
+}
+
+// dryRun reports whether the request only asks for the plan
+func dryRun(r *http.Request) bool {
+	value, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return value
+}
+
+// statusClientClosedRequest is the non-standard status of operations cancelled before completing,
+// as the client disconnected or the server was interrupted
+const statusClientClosedRequest = 499
+
+// statusCode maps an operation error to an http status code
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, merrors.ErrMigrationTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, merrors.ErrConnection):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, merrors.ErrNoAppliedMigrations),
+		errors.Is(err, merrors.ErrDirty),
+		errors.Is(err, merrors.ErrChecksumMismatch),
+		errors.Is(err, merrors.ErrLockTimeout),
+		errors.Is(err, merrors.ErrOutOfOrder):
+		return http.StatusConflict
+	case errors.Is(err, merrors.ErrInvalidMigration),
+		errors.Is(err, merrors.ErrMigrationFailed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func splitLines(s string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hchenc/migrator/pkg/client"
+	_ "github.com/hchenc/migrator/pkg/drivers/sqlite"
+	merrors "github.com/hchenc/migrator/pkg/errors"
+)
+
+const testToken = "secret"
+
+// newTestServer returns a server migrating an in-memory sqlite database with a single migration
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	databaseUrl, err := url.Parse("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg, err := client.NewMigratorClient(databaseUrl, "", "", "", "schema_history", io.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg.Source = client.NewFSSource(fstest.MapFS{
+		"001_a.sql": &fstest.MapFile{Data: []byte("-- migrate:up\ncreate table a (id int);\n\n-- migrate:down\ndrop table a;\n")},
+	}, ".")
+	return NewServer(mg, testToken)
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		want          int
+	}{
+		{name: "missing token", method: http.MethodGet, path: "/status", want: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/status", authorization: "Bearer other", want: http.StatusUnauthorized},
+		{name: "missing bearer prefix", method: http.MethodGet, path: "/status", authorization: testToken, want: http.StatusUnauthorized},
+		{name: "other scheme", method: http.MethodGet, path: "/status", authorization: "Basic " + testToken, want: http.StatusUnauthorized},
+		{name: "status", method: http.MethodGet, path: "/status", authorization: "Bearer " + testToken, want: http.StatusOK},
+		{name: "wrong method", method: http.MethodGet, path: "/migrate", authorization: "Bearer " + testToken, want: http.StatusMethodNotAllowed},
+		{name: "dry run", method: http.MethodPost, path: "/migrate?dry_run=true", authorization: "Bearer " + testToken, want: http.StatusOK},
+		{name: "invalid step", method: http.MethodPost, path: "/up?step=0", authorization: "Bearer " + testToken, want: http.StatusBadRequest},
+		{name: "nothing to roll back", method: http.MethodPost, path: "/rollback", authorization: "Bearer " + testToken, want: http.StatusConflict},
+		{name: "unknown path", method: http.MethodGet, path: "/unknown", authorization: "Bearer " + testToken, want: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			newTestServer(t).Handler().ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Errorf("%s %s = %d, want %d: %s", test.method, test.path, w.Code, test.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestServerNoAuth(t *testing.T) {
+	s := newTestServer(t)
+	s.token = ""
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestServerMigrate(t *testing.T) {
+	s := newTestServer(t)
+	r := httptest.NewRequest(http.MethodPost, "/migrate", nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /migrate = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var resp response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "" || resp.ExitCode != 0 || len(resp.Log) == 0 {
+		t.Errorf("POST /migrate = %+v, want a successful response with its log", resp)
+	}
+}
+
+func TestServerBusy(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+	s.running <- struct{}{}
+
+	r := httptest.NewRequest(http.MethodPost, "/migrate", nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("POST /migrate while running = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+
+	<-s.running
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("POST /migrate once done = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "bad request", err: fmt.Errorf("%w: invalid step", errBadRequest), want: http.StatusBadRequest},
+		{name: "cancelled", err: context.Canceled, want: statusClientClosedRequest},
+		{name: "cancelled migration", err: &merrors.MigrationError{Filename: "001_a.sql", Err: context.Canceled}, want: statusClientClosedRequest},
+		{name: "migration timeout", err: fmt.Errorf("%w: 001_a.sql", merrors.ErrMigrationTimeout), want: http.StatusGatewayTimeout},
+		{name: "deadline exceeded", err: &merrors.MigrationError{Filename: "001_a.sql", Err: context.DeadlineExceeded}, want: http.StatusGatewayTimeout},
+		{name: "connection", err: &merrors.ConnectionError{Err: errors.New("refused")}, want: http.StatusServiceUnavailable},
+		{name: "no applied migrations", err: merrors.ErrNoAppliedMigrations, want: http.StatusConflict},
+		{name: "dirty", err: &merrors.DirtyError{Version: "001"}, want: http.StatusConflict},
+		{name: "checksum mismatch", err: merrors.ErrChecksumMismatch, want: http.StatusConflict},
+		{name: "lock timeout", err: merrors.ErrLockTimeout, want: http.StatusConflict},
+		{name: "out of order", err: merrors.ErrOutOfOrder, want: http.StatusConflict},
+		{name: "invalid migration", err: merrors.ErrInvalidMigration, want: http.StatusUnprocessableEntity},
+		{name: "migration failed", err: &merrors.MigrationError{Filename: "001_a.sql", Err: errors.New("syntax error")}, want: http.StatusUnprocessableEntity},
+		{name: "other", err: errors.New("unexpected"), want: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := statusCode(test.err); got != test.want {
+				t.Errorf("statusCode(%v) = %d, want %d", test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// RunCommand runs a command with the given args and returns its stdout
+func RunCommand(name string, args ...string) ([]byte, error) {
+	return RunCommandContext(context.Background(), name, args...)
+}
+
+// RunCommandContext is RunCommand killing the command when ctx is done
+func RunCommandContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// return stderr if available
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, fmt.Errorf("%s", s)
+		}
+
+		// otherwise return error
+		return nil, err
+	}
+
+	// return stdout
+	return stdout.Bytes(), nil
+}
+
+// TrimLeadingSQLComments removes sql comments and blank lines from the beginning of text
+// generally when performing sql dumps these contain host-specific information such as
+// client/server version numbers
+func TrimLeadingSQLComments(data []byte) ([]byte, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+
+	for len(lines) > 0 {
+		if !IsEmptyLine(lines[0]) && !IsCommentLine(lines[0]) {
+			break
+		}
+		lines = lines[1:]
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
@@ -1,20 +1,17 @@
 package utils
 
 import (
-	"fmt"
 	"net/url"
-	"strings"
 )
 
 func GetSchameName(u *url.URL) string {
+	// opaque urls such as sqlite:db.sqlite3 carry the name in the opaque part
+	if u.Opaque != "" {
+		return u.Opaque
+	}
 	name := u.Path
 	if len(name) > 0 && name[:1] == "/" {
 		name = name[1:]
 	}
 	return name
 }
-
-func FormateDatabaseStr(str string) string {
-	str = strings.Replace(str, "`", "\\`", -1)
-	return fmt.Sprintf("`%s`", str)
-}
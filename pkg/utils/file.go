@@ -1,26 +1,47 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/hchenc/migrator/pkg/errors"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 )
 
 var MigrationFileRegexp = regexp.MustCompile(`^\d.*\.sql$`)
-var UpRegExp = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|\s+\S+)`)
-var DownRegExp = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|\s+\S+)$`)
+
+// RepeatablePrefix starts the name of repeatable migration files, e.g. R__views.sql
+const RepeatablePrefix = "R__"
+
+var RepeatableMigrationFileRegexp = regexp.MustCompile(`^R__.+\.sql$`)
+var UpRegExp = regexp.MustCompile(`(?m)^--\s*migrate:up\b[^\n]*$`)
+var DownRegExp = regexp.MustCompile(`(?m)^--\s*migrate:down\b[^\n]*$`)
 var EmptyLineRegExp = regexp.MustCompile(`^\s*$`)
 var CommentLineRegExp = regexp.MustCompile(`^\s*--`)
 var WhitespaceRegExp = regexp.MustCompile(`\s+`)
 var OptionSeparatorRegExp = regexp.MustCompile(`:`)
-var BlockDirectiveRegExp = regexp.MustCompile(`^--\s*migrate:[up|down]]`)
+var BlockDirectiveRegExp = regexp.MustCompile(`^--\s*migrate:(up|down)\b`)
+
+// FindMigrationFiles lists the files of the dir directory matching re, in order
+func FindMigrationFiles(dir string, re *regexp.Regexp) ([]string, error) {
+	return findMigrationFiles(os.DirFS(dir), ".", dir, re)
+}
+
+// FindMigrationFilesFS lists the files of dir in fsys matching re, in order
+func FindMigrationFilesFS(fsys fs.FS, dir string, re *regexp.Regexp) ([]string, error) {
+	return findMigrationFiles(fsys, dir, dir, re)
+}
 
-func MustFindMigrationFiles(dir string, re *regexp.Regexp) []string {
-	files, err := os.ReadDir(dir)
+// findMigrationFiles lists the files of dir in fsys matching re, reporting errors with name
+func findMigrationFiles(fsys fs.FS, dir, name string, re *regexp.Regexp) ([]string, error) {
+	files, err := fs.ReadDir(fsys, dir)
 	if err != nil {
-		panic(fmt.Sprintf("could not find migrations directory `%s`", dir))
+		return nil, fmt.Errorf("%w `%s`", errors.ErrMigrationsDirectory, name)
 	}
 
 	matches := []string{}
@@ -39,29 +60,81 @@ func MustFindMigrationFiles(dir string, re *regexp.Regexp) []string {
 
 	sort.Strings(matches)
 	if len(matches) == 0 {
-		panic(fmt.Sprintf("no migration files found"))
+		return nil, errors.ErrNoMigrations
 	}
 
-	return matches
+	return matches, nil
 }
 
-func MustFindMigrationFile(dir string, ver string) string {
+// FindMigrationFile returns the file of the dir directory holding version ver
+func FindMigrationFile(dir string, ver string) (string, error) {
+	return findMigrationFile(os.DirFS(dir), ".", dir, ver)
+}
+
+// FindMigrationFileFS returns the file of dir in fsys holding version ver
+func FindMigrationFileFS(fsys fs.FS, dir string, ver string) (string, error) {
+	return findMigrationFile(fsys, dir, dir, ver)
+}
+
+func findMigrationFile(fsys fs.FS, dir, name string, ver string) (string, error) {
 	if ver == "" {
-		panic("migration version is required")
+		return "", fmt.Errorf("migration version is required")
 	}
 
 	ver = regexp.QuoteMeta(ver)
 	re := regexp.MustCompile(fmt.Sprintf(`^%s.*\.sql$`, ver))
 
-	files := MustFindMigrationFiles(dir, re)
+	files, err := findMigrationFiles(fsys, dir, name, re)
+	if err != nil {
+		return "", err
+	}
 
-	return files[0]
+	return files[0], nil
 }
 
+// MigrationVersion returns the version prefix of a migration file, or the name of a repeatable
+// migration file without extension, e.g. R__views.sql -> R__views
 func MigrationVersion(filename string) string {
+	if IsRepeatable(filename) {
+		return strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
 	return regexp.MustCompile(`^\d+`).FindString(filename)
 }
 
+// IsRepeatable reports whether a migration file or version is a repeatable migration
+func IsRepeatable(name string) bool {
+	return strings.HasPrefix(name, RepeatablePrefix)
+}
+
+// CompareVersions compares two numeric migration versions, returning -1, 0 or 1
+func CompareVersions(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// MigrationDescription returns the filename suffix, e.g. 20210101000000_create_users.sql -> create_users
+func MigrationDescription(filename string) string {
+	if IsRepeatable(filename) {
+		return strings.TrimPrefix(MigrationVersion(filename), RepeatablePrefix)
+	}
+	description := strings.TrimPrefix(filename, MigrationVersion(filename))
+	description = strings.TrimSuffix(description, filepath.Ext(description))
+	return strings.TrimLeft(description, "_-. ")
+}
+
+// Checksum returns the hex encoded sha256 of the migration contents
+func Checksum(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
 func EnsureDir(dir string) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("unable to create directory `%s`", dir)
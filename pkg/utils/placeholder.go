@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholderRegExp matches ${name} placeholders
+var placeholderRegExp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.]*)\}`)
+
+// ReplacePlaceholders replaces the ${name} placeholders of sql with the value returned by lookup,
+// failing with every name lookup does not know
+func ReplacePlaceholders(sql string, lookup func(name string) (string, bool)) (string, error) {
+	var undefined []string
+	seen := map[string]bool{}
+	result := placeholderRegExp.ReplaceAllStringFunc(sql, func(match string) string {
+		name := match[2 : len(match)-1]
+		if value, ok := lookup(name); ok {
+			return value
+		}
+		if !seen[name] {
+			seen[name] = true
+			undefined = append(undefined, match)
+		}
+		return match
+	})
+
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("undefined placeholder %s", strings.Join(undefined, ", "))
+	}
+	return result, nil
+}
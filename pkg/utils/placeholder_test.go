@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplacePlaceholders(t *testing.T) {
+	values := map[string]string{"schema": "app", "owner": "admin", "db.name": "shop"}
+	lookup := func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "no placeholders",
+			sql:  "select 1;",
+			want: "select 1;",
+		},
+		{
+			name: "identifiers",
+			sql:  "create table ${schema}.a (id int);\nalter table ${schema}.a owner to ${owner};",
+			want: "create table app.a (id int);\nalter table app.a owner to admin;",
+		},
+		{
+			name: "dotted name",
+			sql:  "use ${db.name};",
+			want: "use shop;",
+		},
+		{
+			name: "inside quoted strings",
+			sql:  "insert into a values ('${owner}', \"${schema}\", E'${schema}\\n');",
+			want: "insert into a values ('admin', \"app\", E'app\\n');",
+		},
+		{
+			name: "not a placeholder",
+			sql:  "select '$schema', '${1x}', '${}';",
+			want: "select '$schema', '${1x}', '${}';",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReplacePlaceholders(test.sql, lookup)
+			if err != nil {
+				t.Fatalf("ReplacePlaceholders() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("ReplacePlaceholders() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReplacePlaceholdersUndefined(t *testing.T) {
+	lookup := func(name string) (string, bool) { return "app", name == "schema" }
+
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{name: "undefined", sql: "select * from ${table};", want: "${table}"},
+		{name: "undefined in quoted string", sql: "select '${missing}' from ${schema}.a;", want: "${missing}"},
+		{name: "each name reported once", sql: "select ${a}, ${b}, ${a};", want: "${a}, ${b}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReplacePlaceholders(test.sql, lookup)
+			if err == nil {
+				t.Fatal("ReplacePlaceholders() error = nil, want an error")
+			}
+			if !strings.HasSuffix(err.Error(), test.want) {
+				t.Errorf("ReplacePlaceholders() error = %q, want it to name %s", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dialect selects the sql syntax understood by SplitStatements
+type Dialect string
+
+const (
+	DialectMysql    Dialect = "mysql"
+	DialectPostgres Dialect = "postgres"
+	DialectSqlite   Dialect = "sqlite"
+)
+
+// DefaultDelimiter ends statements until a mysql DELIMITER command changes it
+const DefaultDelimiter = ";"
+
+// Statement is a single sql statement of a migration
+type Statement struct {
+	SQL string
+	// Line is the line the statement starts on, counted from 1
+	Line int
+}
+
+var (
+	delimiterRegExp = regexp.MustCompile(`(?i)^delimiter[ \t]+(\S+)[ \t]*(\r?\n|$)`)
+	dollarTagRegExp = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+	triggerRegExp   = regexp.MustCompile(`(?i)^create\s+(temp\s+|temporary\s+)?trigger\s`)
+)
+
+// SplitStatements splits sql into statements, skipping the delimiters found in quoted strings,
+// identifiers and comments. Depending on dialect it also understands the mysql DELIMITER
+// command and # comments, postgres dollar-quoted strings and sqlite trigger bodies.
+// Statements made only of comments are dropped.
+func SplitStatements(sql string, dialect Dialect) ([]Statement, error) {
+	s := &splitter{sql: sql, dialect: dialect, delimiter: DefaultDelimiter, line: 1, start: -1}
+	if err := s.split(); err != nil {
+		return nil, err
+	}
+	return s.statements, nil
+}
+
+type splitter struct {
+	sql       string
+	dialect   Dialect
+	delimiter string
+	pos       int
+	line      int
+	// start is the offset of the current statement, -1 between statements
+	start     int
+	startLine int
+	// depth counts the BEGIN and CASE blocks left open in a sqlite trigger body
+	depth      int
+	statements []Statement
+}
+
+func (s *splitter) split() error {
+	for s.pos < len(s.sql) {
+		c := s.sql[s.pos]
+		rest := s.sql[s.pos:]
+
+		switch {
+		case s.start < 0 && isSpace(c):
+			s.advance(1)
+		case s.isLineComment(rest):
+			s.skipLine()
+		case strings.HasPrefix(rest, "/*"):
+			if s.start < 0 && s.isExecutableComment(rest) {
+				s.start = s.pos
+				s.startLine = s.line
+			}
+			if err := s.skipBlockComment(); err != nil {
+				return err
+			}
+		case s.start < 0 && s.dialect == DialectMysql && delimiterRegExp.MatchString(rest):
+			match := delimiterRegExp.FindStringSubmatch(rest)
+			s.delimiter = match[1]
+			s.advance(len(match[0]))
+		case s.depth == 0 && strings.HasPrefix(rest, s.delimiter):
+			s.end()
+			s.advance(len(s.delimiter))
+		default:
+			if s.start < 0 {
+				s.start = s.pos
+				s.startLine = s.line
+			}
+			if err := s.token(c, rest); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.end()
+	return nil
+}
+
+// token skips the quoted string, identifier or single character starting the rest of a statement
+func (s *splitter) token(c byte, rest string) error {
+	switch {
+	case c == '\'':
+		return s.skipQuoted("'", "'", s.backslashEscapes())
+	case c == '"':
+		return s.skipQuoted(`"`, `"`, s.dialect == DialectMysql)
+	case c == '`' && s.dialect != DialectPostgres:
+		return s.skipQuoted("`", "`", false)
+	case c == '[' && s.dialect == DialectSqlite:
+		return s.skipQuoted("[", "]", false)
+	case c == '$' && s.dialect == DialectPostgres && !s.precededByWord() && dollarTagRegExp.MatchString(rest):
+		tag := dollarTagRegExp.FindString(rest)
+		return s.skipQuoted(tag, tag, false)
+	case isWordChar(c):
+		s.word()
+	default:
+		s.advance(1)
+	}
+	return nil
+}
+
+// word skips a keyword or identifier, tracking the blocks of sqlite trigger bodies
+// which contain semicolons
+func (s *splitter) word() {
+	end := s.pos
+	// a custom delimiter such as $$ may directly follow a word
+	for end < len(s.sql) && isWordChar(s.sql[end]) && !strings.HasPrefix(s.sql[end:], s.delimiter) {
+		end++
+	}
+	if end == s.pos {
+		end++
+	}
+	word := strings.ToUpper(s.sql[s.pos:end])
+	s.advance(end - s.pos)
+
+	if s.dialect != DialectSqlite || !triggerRegExp.MatchString(s.sql[s.start:]) {
+		return
+	}
+	switch word {
+	case "BEGIN", "CASE":
+		s.depth++
+	case "END":
+		if s.depth > 0 {
+			s.depth--
+		}
+	}
+}
+
+// end records the current statement, if any
+func (s *splitter) end() {
+	if s.start >= 0 {
+		s.statements = append(s.statements, Statement{
+			SQL:  strings.TrimSpace(s.sql[s.start:s.pos]),
+			Line: s.startLine,
+		})
+	}
+	s.start = -1
+	s.depth = 0
+}
+
+func (s *splitter) isLineComment(rest string) bool {
+	if s.dialect == DialectMysql {
+		// mysql requires whitespace after the double dash
+		return rest == "--" || (strings.HasPrefix(rest, "--") && isSpace(rest[2])) || rest[0] == '#'
+	}
+	return strings.HasPrefix(rest, "--")
+}
+
+// isExecutableComment reports whether rest starts with a mysql comment which is executed,
+// such as /*!40101 SET NAMES utf8mb4 */, or an optimizer hint, which belong to the statement
+func (s *splitter) isExecutableComment(rest string) bool {
+	return s.dialect == DialectMysql && (strings.HasPrefix(rest, "/*!") || strings.HasPrefix(rest, "/*+"))
+}
+
+func (s *splitter) skipLine() {
+	end := strings.IndexByte(s.sql[s.pos:], '\n')
+	if end < 0 {
+		s.advance(len(s.sql) - s.pos)
+		return
+	}
+	s.advance(end + 1)
+}
+
+func (s *splitter) skipBlockComment() error {
+	line := s.line
+	// postgres comments nest
+	depth := 0
+	for s.pos < len(s.sql) {
+		rest := s.sql[s.pos:]
+		switch {
+		case strings.HasPrefix(rest, "/*"):
+			depth++
+			s.advance(2)
+		case strings.HasPrefix(rest, "*/"):
+			depth--
+			s.advance(2)
+			if depth == 0 || s.dialect != DialectPostgres {
+				return nil
+			}
+		default:
+			s.advance(1)
+		}
+	}
+	return fmt.Errorf("unterminated comment starting at line %d", line)
+}
+
+// skipQuoted skips from the open quote to the matching close quote, a doubled close quote
+// being an escaped one
+func (s *splitter) skipQuoted(open, close string, backslash bool) error {
+	line := s.line
+	s.advance(len(open))
+	for s.pos < len(s.sql) {
+		rest := s.sql[s.pos:]
+		switch {
+		case backslash && rest[0] == '\\' && len(rest) > 1:
+			s.advance(2)
+		case strings.HasPrefix(rest, close+close) && len(close) == 1:
+			s.advance(2)
+		case strings.HasPrefix(rest, close):
+			s.advance(len(close))
+			return nil
+		default:
+			s.advance(1)
+		}
+	}
+	return fmt.Errorf("unterminated %s starting at line %d", quoteName(open), line)
+}
+
+// backslashEscapes reports whether backslashes escape quotes in the string starting at pos,
+// as in mysql strings and postgres E'' strings
+func (s *splitter) backslashEscapes() bool {
+	switch s.dialect {
+	case DialectMysql:
+		return true
+	case DialectPostgres:
+		if s.pos == 0 || (s.sql[s.pos-1] != 'E' && s.sql[s.pos-1] != 'e') {
+			return false
+		}
+		return s.pos == 1 || !isWordChar(s.sql[s.pos-2])
+	}
+	return false
+}
+
+func (s *splitter) precededByWord() bool {
+	return s.pos > 0 && isWordChar(s.sql[s.pos-1])
+}
+
+func (s *splitter) advance(n int) {
+	s.line += strings.Count(s.sql[s.pos:s.pos+n], "\n")
+	s.pos += n
+}
+
+func quoteName(open string) string {
+	switch open {
+	case "'":
+		return "quoted string"
+	case `"`, "`", "[":
+		return "quoted identifier"
+	}
+	return "dollar-quoted string"
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
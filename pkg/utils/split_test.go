@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		sql     string
+		want    []Statement
+	}{
+		{
+			name:    "simple statements",
+			dialect: DialectSqlite,
+			sql:     "create table a (id int);\ninsert into a values (1);\n",
+			want: []Statement{
+				{SQL: "create table a (id int)", Line: 1},
+				{SQL: "insert into a values (1)", Line: 2},
+			},
+		},
+		{
+			name:    "missing trailing delimiter",
+			dialect: DialectPostgres,
+			sql:     "select 1;\nselect 2",
+			want:    []Statement{{SQL: "select 1", Line: 1}, {SQL: "select 2", Line: 2}},
+		},
+		{
+			name:    "quoted semicolons",
+			dialect: DialectPostgres,
+			sql:     "insert into a values ('a;b', 'it''s;');\nselect \"x;y\" from a;",
+			want: []Statement{
+				{SQL: "insert into a values ('a;b', 'it''s;')", Line: 1},
+				{SQL: "select \"x;y\" from a", Line: 2},
+			},
+		},
+		{
+			name:    "comments only statements are dropped",
+			dialect: DialectPostgres,
+			sql:     "-- a comment; with a semicolon\n/* block; */\nselect 1; -- trailing\n",
+			want:    []Statement{{SQL: "select 1", Line: 3}},
+		},
+		{
+			name:    "mysql delimiter $$",
+			dialect: DialectMysql,
+			sql: "DELIMITER $$\n" +
+				"create procedure p() begin select 1; select 2; end$$\n" +
+				"DELIMITER ;\n" +
+				"select 3;\n",
+			want: []Statement{
+				{SQL: "create procedure p() begin select 1; select 2; end", Line: 2},
+				{SQL: "select 3", Line: 4},
+			},
+		},
+		{
+			name:    "mysql delimiter //",
+			dialect: DialectMysql,
+			sql: "delimiter //\n" +
+				"create trigger t before insert on a for each row\nbegin\n  set new.id = 1;\nend //\n" +
+				"delimiter ;\n",
+			want: []Statement{
+				{SQL: "create trigger t before insert on a for each row\nbegin\n  set new.id = 1;\nend", Line: 2},
+			},
+		},
+		{
+			name:    "mysql backslash escapes and comments",
+			dialect: DialectMysql,
+			sql:     "insert into a values ('it\\'s;', \"a\\\";\");\n# hash comment;\nselect `a;b` from a;",
+			want: []Statement{
+				{SQL: "insert into a values ('it\\'s;', \"a\\\";\")", Line: 1},
+				{SQL: "select `a;b` from a", Line: 3},
+			},
+		},
+		{
+			name:    "mysql executable comments",
+			dialect: DialectMysql,
+			sql: "/*!40101 SET NAMES utf8mb4 */;\nselect 1;\n" +
+				"/*!50003 CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.id = 1; END */;;\n" +
+				"/* a regular comment */ select 2;\n",
+			want: []Statement{
+				{SQL: "/*!40101 SET NAMES utf8mb4 */", Line: 1},
+				{SQL: "select 1", Line: 2},
+				{SQL: "/*!50003 CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.id = 1; END */", Line: 3},
+				{SQL: "select 2", Line: 4},
+			},
+		},
+		{
+			name:    "mysql optimizer hints",
+			dialect: DialectMysql,
+			sql:     "/*+ MAX_EXECUTION_TIME(1000) */ select 1;\nselect /*+ BKA(a) */ * from a;",
+			want: []Statement{
+				{SQL: "/*+ MAX_EXECUTION_TIME(1000) */ select 1", Line: 1},
+				{SQL: "select /*+ BKA(a) */ * from a", Line: 2},
+			},
+		},
+		{
+			name:    "executable comments are regular comments in postgres",
+			dialect: DialectPostgres,
+			sql:     "/*! not mysql; */ select 1;",
+			want:    []Statement{{SQL: "select 1", Line: 1}},
+		},
+		{
+			name:    "postgres dollar quotes",
+			dialect: DialectPostgres,
+			sql: "create function f() returns int as $$ begin return 1; end; $$ language plpgsql;\n" +
+				"create function g() returns text as $body$ select 'a;$$;b' $body$ language sql;\n",
+			want: []Statement{
+				{SQL: "create function f() returns int as $$ begin return 1; end; $$ language plpgsql", Line: 1},
+				{SQL: "create function g() returns text as $body$ select 'a;$$;b' $body$ language sql", Line: 2},
+			},
+		},
+		{
+			name:    "postgres dollar in identifier",
+			dialect: DialectPostgres,
+			sql:     "select a$1 from t; select 2;",
+			want:    []Statement{{SQL: "select a$1 from t", Line: 1}, {SQL: "select 2", Line: 1}},
+		},
+		{
+			name:    "postgres nested block comments",
+			dialect: DialectPostgres,
+			sql:     "/* outer /* inner; */ still; a comment */\nselect 1;\nselect /* x; */ 2;",
+			want:    []Statement{{SQL: "select 1", Line: 2}, {SQL: "select /* x; */ 2", Line: 3}},
+		},
+		{
+			name:    "postgres escape strings",
+			dialect: DialectPostgres,
+			sql:     "select E'it\\'s;', e'\\\\';\nselect 'a\\';",
+			want: []Statement{
+				{SQL: "select E'it\\'s;', e'\\\\'", Line: 1},
+				{SQL: "select 'a\\'", Line: 2},
+			},
+		},
+		{
+			name:    "postgres backslash in a standard string",
+			dialect: DialectPostgres,
+			sql:     "select 'a\\'; select 'b';",
+			want:    []Statement{{SQL: "select 'a\\'", Line: 1}, {SQL: "select 'b'", Line: 1}},
+		},
+		{
+			name:    "sqlite trigger with case",
+			dialect: DialectSqlite,
+			sql: "create trigger t after insert on a\nbegin\n" +
+				"  update a set kind = case when new.id > 0 then 'pos;' else 'neg' end;\n" +
+				"  insert into log values (new.id);\nend;\n" +
+				"select [a;b] from a;\n",
+			want: []Statement{
+				{SQL: "create trigger t after insert on a\nbegin\n" +
+					"  update a set kind = case when new.id > 0 then 'pos;' else 'neg' end;\n" +
+					"  insert into log values (new.id);\nend", Line: 1},
+				{SQL: "select [a;b] from a", Line: 6},
+			},
+		},
+		{
+			name:    "sqlite begin outside a trigger",
+			dialect: DialectSqlite,
+			sql:     "begin;\nselect case when 1 then 2 end;\ncommit;",
+			want: []Statement{
+				{SQL: "begin", Line: 1},
+				{SQL: "select case when 1 then 2 end", Line: 2},
+				{SQL: "commit", Line: 3},
+			},
+		},
+		{
+			name:    "line numbers after multiline statements",
+			dialect: DialectPostgres,
+			sql:     "\n\ncreate table a (\n  id int\n);\n\n\ninsert into a\nvalues (1);",
+			want:    []Statement{{SQL: "create table a (\n  id int\n)", Line: 3}, {SQL: "insert into a\nvalues (1)", Line: 8}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := SplitStatements(test.sql, test.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SplitStatements() =\n%#v\nwant\n%#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		sql     string
+		want    string
+	}{
+		{"quoted string", DialectPostgres, "select 1;\nselect 'a;", "unterminated quoted string starting at line 2"},
+		{"quoted identifier", DialectMysql, "select 1;\n\nselect `a;", "unterminated quoted identifier starting at line 3"},
+		{"dollar-quoted string", DialectPostgres, "select $x$ a;", "unterminated dollar-quoted string starting at line 1"},
+		{"nested comment", DialectPostgres, "/* a /* b */\nselect 1;", "unterminated comment starting at line 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := SplitStatements(test.sql, test.dialect)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("SplitStatements() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
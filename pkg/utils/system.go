@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"os"
+	"os/user"
+)
+
+// CurrentUser returns the name of the user running the migrator
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Hostname returns the name of the host running the migrator
+func Hostname() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}